@@ -19,6 +19,7 @@ package utter
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
@@ -26,6 +27,9 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 	"unsafe"
 )
 
@@ -151,17 +155,84 @@ func unsafeReflectValue(v reflect.Value) (rv reflect.Value) {
 	return rv
 }
 
+var (
+	// chanBufOffset and chanRecvxOffset are the offsets of the ring buffer
+	// pointer and the receive index in the runtime's channel header. The
+	// buffer pointer follows the queue count and the buffer size. The
+	// position of the receive index has changed between Go versions, so
+	// it is found by probing a channel in a known state. A zero value for
+	// chanRecvxOffset indicates that the layout could not be determined.
+	chanBufOffset   = 2 * ptrSize
+	chanRecvxOffset uintptr
+)
+
+func init() {
+	// Construct a channel with a known queue state. The send index
+	// will be 5 and the receive index will be 3.
+	const (
+		size  = 7
+		sendx = 5
+		recvx = 3
+	)
+	c := make(chan int, size)
+	for i := 0; i < sendx; i++ {
+		c <- i
+	}
+	for i := 0; i < recvx; i++ {
+		<-c
+	}
+
+	// The channel header is at least twelve words long on all supported
+	// versions, so only search within that range. The send index
+	// immediately precedes the receive index.
+	hdr := (*[12]uintptr)(unsafe.Pointer(reflect.ValueOf(c).Pointer()))
+	if hdr[0] != sendx-recvx || hdr[1] != size {
+		return
+	}
+	for i := 3; i < len(hdr)-1; i++ {
+		if hdr[i] == sendx && hdr[i+1] == recvx {
+			chanRecvxOffset = uintptr(i+1) * ptrSize
+			return
+		}
+	}
+}
+
+// chanElems returns the elements queued in the buffered channel v without
+// receiving them. Access to the channel's buffer is not synchronised with
+// other users of the channel. If the layout of the runtime's channel header
+// is not known, chanElems returns false.
+func chanElems(v reflect.Value) ([]reflect.Value, bool) {
+	if chanRecvxOffset == 0 {
+		return nil, false
+	}
+	hdr := unsafe.Pointer(v.Pointer())
+	buf := *(*unsafe.Pointer)(unsafe.Pointer(uintptr(hdr) + chanBufOffset))
+	recvx := *(*uint)(unsafe.Pointer(uintptr(hdr) + chanRecvxOffset))
+	size := uint(v.Cap())
+	typ := v.Type().Elem()
+	elems := make([]reflect.Value, v.Len())
+	for i := range elems {
+		idx := (recvx + uint(i)) % size
+		elems[i] = reflect.NewAt(typ, unsafe.Pointer(uintptr(buf)+uintptr(idx)*typ.Size())).Elem()
+	}
+	return elems, true
+}
+
 // Some constants in the form of bytes to avoid string overhead.  This mirrors
 // the technique used in the fmt package.
 var (
 	backQuoteBytes        = []byte("`")
 	quoteBytes            = []byte(`"`)
 	plusBytes             = []byte("+")
+	concatNewlineBytes    = []byte(" +\n")
 	iBytes                = []byte("i")
+	complexOpenBytes      = []byte("complex(")
+	float32OpenBytes      = []byte("float32(")
 	trueBytes             = []byte("true")
 	falseBytes            = []byte("false")
 	interfaceBytes        = []byte("interface{}")
 	interfaceTypeBytes    = []byte("interface {}")
+	commaBytes            = []byte(",")
 	commaSpaceBytes       = []byte(", ")
 	commaNewlineBytes     = []byte(",\n")
 	newlineBytes          = []byte("\n")
@@ -169,18 +240,30 @@ var (
 	openBraceNewlineBytes = []byte("{\n")
 	closeBraceBytes       = []byte("}")
 	ampersandBytes        = []byte("&")
+	colonBytes            = []byte(":")
 	colonSpaceBytes       = []byte(": ")
 	spaceBytes            = []byte(" ")
 	openParenBytes        = []byte("(")
 	closeParenBytes       = []byte(")")
+	openBracketBytes      = []byte("[")
+	closeBracketBytes     = []byte("]")
 	nilBytes              = []byte("nil")
 	hexZeroBytes          = []byte("0x")
+	octZeroBytes          = []byte("0o")
+	binZeroBytes          = []byte("0b")
+	minusBytes            = []byte("-")
 	zeroBytes             = []byte("0")
 	pointZeroBytes        = []byte(".0")
 	openCommentBytes      = []byte(" /*")
 	closeCommentBytes     = []byte("*/ ")
+	lineCommentBytes      = []byte("// ")
 	pointerChainBytes     = []byte("->")
+	chanSendBytes         = []byte("c <- ")
+	returnChanBytes       = []byte("return c\n")
+	callBytes             = []byte("()")
 	circularBytes         = []byte("(<already shown>)")
+	circularNilBytes      = []byte("(nil /* already shown */)")
+	invalidNilBytes       = []byte("nil /* invalid */")
 	invalidAngleBytes     = []byte("<invalid>")
 )
 
@@ -196,41 +279,357 @@ func printBool(w io.Writer, val bool) {
 	}
 }
 
-// printInt outputs a signed integer value to Writer w.
-func printInt(w io.Writer, val int64, base int) {
-	w.Write([]byte(strconv.FormatInt(val, base)))
-}
+// appendInteger appends an integer value with the magnitude val to dst as a
+// Go literal in the given base, which must be 2, 8, 10 or 16. The value is
+// negative if neg is true. If group is true, digits are separated into
+// groups by underscores. If pad is true and the base is not 10, the digits
+// are zero padded to the width of an integer with the given number of bits.
+func appendInteger(dst []byte, val uint64, neg bool, bits, base int, group, pad bool) []byte {
+	if neg {
+		dst = append(dst, '-')
+	}
+	switch base {
+	case 2:
+		dst = append(dst, binZeroBytes...)
+	case 8:
+		dst = append(dst, octZeroBytes...)
+	case 16:
+		dst = append(dst, hexZeroBytes...)
+	}
+	if !pad && !group {
+		return strconv.AppendUint(dst, val, base)
+	}
 
-// printUint outputs an unsigned integer value to Writer w.
-func printUint(w io.Writer, val uint64, base int) {
-	w.Write([]byte(strconv.FormatUint(val, base)))
+	// Format the digits at the end of dst and then
+	// move them into place, padded and grouped.
+	start := len(dst)
+	dst = strconv.AppendUint(dst, val, base)
+	n := len(dst) - start
+	width := n
+	if pad {
+		switch base {
+		case 2:
+			width = bits
+		case 8:
+			width = (bits + 2) / 3
+		case 16:
+			width = bits / 4
+		}
+		if width < n {
+			width = n
+		}
+	}
+	size := 3
+	if base == 2 || base == 16 {
+		size = 4
+	}
+	total := width
+	if group {
+		total += (width - 1) / size
+	}
+	for len(dst) < start+n+total {
+		dst = append(dst, 0)
+	}
+	digits := dst[start : start+n]
+	out := dst[start+n : start+n+total]
+	for i, j := total-1, 0; i >= 0; i-- {
+		if group && j != 0 && j%size == 0 {
+			out[i] = '_'
+			i--
+		}
+		if j < n {
+			out[i] = digits[n-1-j]
+		} else {
+			out[i] = '0'
+		}
+		j++
+	}
+	copy(dst[start:], out)
+	return dst[:start+total]
 }
 
-// printFloat outputs a floating point value using the specified precision,
-// which is expected to be 32 or 64bit, to Writer w.
-func printFloat(w io.Writer, val float64, precision int, typeElided bool) {
-	w.Write([]byte(strconv.FormatFloat(val, 'g', -1, precision)))
+// appendFloat appends a floating point value using the specified precision,
+// which is expected to be 32 or 64bit, to dst. If specials is true, NaN,
+// infinite and negative zero values are written as math package function
+// calls. If hex is true, values are written as exact hexadecimal literals and
+// NaN values are written as conversions from their bit pattern. If typeElided
+// is true, calls are converted to the type of the value when their result has
+// a different type. The type is given by named for values of named types and
+// must be nil otherwise.
+func appendFloat(dst []byte, val float64, precision int, named []byte, typeElided, specials, hex bool) []byte {
+	if (specials || hex) && isSpecial(val) {
+		if hex && math.IsNaN(val) {
+			wrap := typeElided && named != nil
+			if wrap {
+				dst = append(dst, named...)
+				dst = append(dst, '(')
+			}
+			if precision == 32 {
+				dst = append(dst, "math.Float32frombits(0x"...)
+				dst = strconv.AppendUint(dst, uint64(math.Float32bits(float32(val))), 16)
+			} else {
+				dst = append(dst, "math.Float64frombits(0x"...)
+				dst = strconv.AppendUint(dst, math.Float64bits(val), 16)
+			}
+			dst = append(dst, ')')
+			if wrap {
+				dst = append(dst, ')')
+			}
+			return dst
+		}
+		wrap := typeElided && (named != nil || precision == 32)
+		if wrap {
+			if named != nil {
+				dst = append(dst, named...)
+				dst = append(dst, '(')
+			} else {
+				dst = append(dst, float32OpenBytes...)
+			}
+		}
+		switch {
+		case math.IsNaN(val):
+			dst = append(dst, "math.NaN()"...)
+		case math.IsInf(val, 1):
+			dst = append(dst, "math.Inf(1)"...)
+		case math.IsInf(val, -1):
+			dst = append(dst, "math.Inf(-1)"...)
+		default:
+			dst = append(dst, "math.Copysign(0, -1)"...)
+		}
+		if wrap {
+			dst = append(dst, ')')
+		}
+		return dst
+	}
+	if hex {
+		return strconv.AppendFloat(dst, val, 'x', -1, precision)
+	}
+	dst = strconv.AppendFloat(dst, val, 'g', -1, precision)
 	if typeElided && !math.IsInf(val, 0) && val == math.Floor(val) {
-		w.Write(pointZeroBytes)
+		dst = append(dst, pointZeroBytes...)
 	}
+	return dst
+}
+
+// isSpecial returns whether val is a floating point value that cannot be
+// represented by a Go constant: NaN, infinities and negative zero.
+func isSpecial(val float64) bool {
+	return math.IsNaN(val) || math.IsInf(val, 0) || (val == 0 && math.Signbit(val))
 }
 
-// printComplex outputs a complex value using the specified float precision
-// for the real and imaginary parts to Writer w.
-func printComplex(w io.Writer, c complex128, floatPrecision int) {
+// appendComplex appends a complex value using the specified float precision
+// for the real and imaginary parts to dst. If calls or hex is true, values
+// with NaN, infinite or negative zero parts are written as a call to the
+// complex builtin with the parts formatted as for appendFloat. If hex is
+// true, parts are written as exact hexadecimal literals. If named is not nil,
+// calls are converted to the named type it holds, since the type of the value
+// is elided.
+func appendComplex(dst []byte, c complex128, floatPrecision int, named []byte, calls, hex bool) []byte {
 	r := real(c)
-	w.Write([]byte(strconv.FormatFloat(r, 'g', -1, floatPrecision)))
 	i := imag(c)
+	if (calls || hex) && (isSpecial(r) || isSpecial(i)) {
+		if named != nil {
+			dst = append(dst, named...)
+			dst = append(dst, '(')
+		}
+		dst = append(dst, complexOpenBytes...)
+		dst = appendComplexPart(dst, r, floatPrecision, hex)
+		dst = append(dst, commaSpaceBytes...)
+		dst = appendComplexPart(dst, i, floatPrecision, hex)
+		dst = append(dst, ')')
+		if named != nil {
+			dst = append(dst, ')')
+		}
+		return dst
+	}
+	format := byte('g')
+	if hex {
+		format = 'x'
+	}
+	dst = strconv.AppendFloat(dst, r, format, -1, floatPrecision)
 	if i >= 0 {
-		w.Write(plusBytes)
+		dst = append(dst, '+')
+	}
+	dst = strconv.AppendFloat(dst, i, format, -1, floatPrecision)
+	return append(dst, 'i')
+}
+
+// appendComplexPart appends a part of a complex value as an argument to the
+// complex builtin. Parts of complex64 values are converted to float32.
+func appendComplexPart(dst []byte, val float64, floatPrecision int, hex bool) []byte {
+	if floatPrecision == 32 {
+		dst = append(dst, float32OpenBytes...)
+		dst = appendFloat(dst, val, floatPrecision, nil, false, true, hex)
+		return append(dst, ')')
 	}
-	w.Write([]byte(strconv.FormatFloat(i, 'g', -1, floatPrecision)))
-	w.Write(iBytes)
+	return appendFloat(dst, val, floatPrecision, nil, true, true, hex)
+}
+
+// aligner aligns the values of the key/value entries of a struct or map
+// following the rules gofmt uses to align the elements of a composite
+// literal. Entries are written to buf and their positions are recorded.
+// The methods recording entry positions are no-ops on a nil aligner.
+type aligner struct {
+	w       io.Writer
+	buf     heldBuffer
+	entries []alignEntry
+	restore func()
+}
+
+// alignEntry holds the offsets in an aligner's buffer of the start of an
+// entry's key, the end of the key's colon and the end of the entry.
+type alignEntry struct {
+	start, colon, end int
+}
+
+// startEntry records the start of an entry's key.
+func (a *aligner) startEntry() {
+	if a == nil {
+		return
+	}
+	a.entries = append(a.entries, alignEntry{start: a.buf.Len()})
+}
+
+// endKey records the end of an entry's key and colon.
+func (a *aligner) endKey() {
+	if a == nil {
+		return
+	}
+	a.entries[len(a.entries)-1].colon = a.buf.Len()
+}
+
+// endEntry records the end of an entry, including its trailing newline.
+func (a *aligner) endEntry() {
+	if a == nil {
+		return
+	}
+	a.entries[len(a.entries)-1].end = a.buf.Len()
+}
+
+// flush writes the recorded entries to w, padding the keys of entries in
+// each aligned section to the same width.
+//
+// Entries are aligned in sections of consecutive single line entries.
+// Entries that span multiple lines and lines between entries end a
+// section. As with gofmt, a section is also ended before an entry when
+// either it or the previous entry has a key longer than 40 bytes and the
+// ratio of its key length to the geometric mean of the key lengths in the
+// section is outside the range (0.4, 2.5).
+func (a *aligner) flush() {
+	const (
+		smallSize = 40
+		ratio     = 2.5
+	)
+	b := a.buf.Bytes()
+
+	// Entries are incomplete if the dump was aborted
+	// while they were written, so leave them unaligned.
+	for len(a.entries) != 0 && a.entries[len(a.entries)-1].end == 0 {
+		a.entries = a.entries[:len(a.entries)-1]
+	}
+
+	// Assign sections and key widths.
+	sections := make([]int, len(a.entries))
+	widths := make([]int, len(a.entries))
+	var (
+		section, prevSize, prevEnd, count int
+		log2sum                           float64
+	)
+	for i, e := range a.entries {
+		size := 0
+		if !bytes.Contains(b[e.start:e.end-1], newlineBytes) {
+			size = e.colon - e.start - 1
+			widths[i] = utf8.RuneCount(b[e.start:e.colon])
+		}
+		newSection := true
+		if prevSize > 0 && size > 0 && !bytes.Contains(b[prevEnd:e.start], newlineBytes) {
+			if count == 0 || prevSize <= smallSize && size <= smallSize {
+				newSection = false
+			} else {
+				r := float64(size) / exp2ish(log2sum/float64(count))
+				newSection = ratio*r <= 1 || ratio <= r
+			}
+		}
+		if newSection {
+			section++
+			log2sum = 0
+			count = 0
+		}
+		if size > 0 {
+			log2sum += log2ish(float64(size))
+			count++
+		}
+		sections[i] = section
+		prevSize = size
+		prevEnd = e.end
+	}
+	if len(a.entries) < 2 {
+		// A single entry is never aligned.
+		widths = widths[:0]
+	}
+
+	// Find the column of each section.
+	columns := make(map[int]int)
+	for i, w := range widths {
+		if w > columns[sections[i]] {
+			columns[sections[i]] = w
+		}
+	}
+
+	var pos int
+	for i, w := range widths {
+		e := a.entries[i]
+		a.w.Write(b[pos:e.colon])
+		if w > 0 {
+			a.w.Write(bytes.Repeat(spaceBytes, columns[sections[i]]-w))
+		}
+		pos = e.colon
+	}
+	a.w.Write(b[pos:])
+}
+
+// log2ish returns the approximation of log₂(x) used by gofmt for alignment
+// decisions.
+func log2ish(x float64) float64 {
+	f, e := math.Frexp(x)
+	return float64(e) + 2*(f-1)
+}
+
+// exp2ish returns the approximation of 2**x used by gofmt for alignment
+// decisions.
+func exp2ish(x float64) float64 {
+	n := math.Floor(x)
+	f := x - n
+	return math.Ldexp(1+f, int(n))
+}
+
+// appendSingleLine appends the lines of the dump b to dst joined by single
+// spaces with their indentation removed. No space is added after an opening
+// brace and the trailing comma of the last element before a closing brace is
+// removed.
+func appendSingleLine(dst, b []byte) []byte {
+	for _, line := range bytes.Split(b, newlineBytes) {
+		line = bytes.TrimLeft(line, " \t")
+		if len(line) == 0 {
+			continue
+		}
+		if n := len(dst); n != 0 {
+			switch {
+			case line[0] == '}' && dst[n-1] == ',':
+				dst = dst[:n-1]
+			case dst[n-1] != '{':
+				dst = append(dst, ' ')
+			}
+		}
+		dst = append(dst, line...)
+	}
+	return dst
 }
 
 // hexDump is a modified 'hexdump -C'-like that returns a commented Go syntax
-// byte slice or array.
-func hexDump(w io.Writer, data []byte, indent string, width int, comment, addr bool) {
+// byte slice or array. Each line is built in line before it is written to w,
+// and line is returned for reuse. The visit function is called for each byte.
+func hexDump(w io.Writer, line, data, indent []byte, width int, comment, addr bool, visit func()) []byte {
 	if width <= 0 {
 		width = 16 // This is the width used by hexdump -C, so it makes a reasonable default.
 	}
@@ -240,21 +639,21 @@ func hexDump(w io.Writer, data []byte, indent string, width int, comment, addr b
 		commentBytes = make([]byte, width)
 	}
 
-	var addrFmt string
-	if addr {
-		addrFmt = fmt.Sprintf("%%#0%dx: ", (bits.Len(uint(len(data)))+3)/4)
-	}
+	addrWidth := (bits.Len(uint(len(data))) + 3) / 4
+	line = line[:0]
 	for i, v := range data {
+		visit()
 		if i%width == 0 {
-			fmt.Fprint(w, indent)
+			line = append(line, indent...)
 			if addr {
-				fmt.Fprintf(w, addrFmt, i)
+				line = appendPaddedHex(line, uint64(i), addrWidth)
+				line = append(line, ':', ' ')
 			}
 		} else {
-			w.Write(spaceBytes)
+			line = append(line, ' ')
 		}
 
-		fmt.Fprintf(w, "%#02x,", v)
+		line = append(line, '0', 'x', hexDigits[v>>4], hexDigits[v&0xf], ',')
 		if comment {
 			if v < 32 || v > 126 {
 				v = '.'
@@ -264,12 +663,16 @@ func hexDump(w io.Writer, data []byte, indent string, width int, comment, addr b
 
 		if !comment {
 			if i%width == width-1 || i == len(data)-1 {
-				fmt.Fprintln(w)
+				line = append(line, '\n')
+				w.Write(line)
+				line = line[:0]
 			}
 			continue
 		}
 		if i%width == width-1 {
-			fmt.Fprintf(w, " // |%s|\n", commentBytes[:])
+			line = appendByteComment(line, commentBytes)
+			w.Write(line)
+			line = line[:0]
 		} else if i == len(data)-1 {
 			if len(data) > width {
 				slots := width - i%width - 1
@@ -277,61 +680,314 @@ func hexDump(w io.Writer, data []byte, indent string, width int, comment, addr b
 				case 0:
 					// Do nothing.
 				case 1:
-					w.Write([]byte(" /* */"))
+					line = append(line, " /* */"...)
 				default:
-					w.Write([]byte(" /*   "))
-					w.Write(bytes.Repeat([]byte("      "), slots-2))
-					w.Write([]byte("    */"))
+					line = append(line, " /*   "...)
+					for j := 0; j < slots-2; j++ {
+						line = append(line, "      "...)
+					}
+					line = append(line, "    */"...)
 				}
 			}
-			fmt.Fprintf(w, " // |%s|\n", commentBytes[:len(data)%width])
+			line = appendByteComment(line, commentBytes[:len(data)%width])
+			w.Write(line)
+			line = line[:0]
 		}
 	}
+	return line
 }
 
-// printHexPtr outputs a uintptr formatted as hexadecimal with a leading '0x'
-// prefix to Writer w.
-func printHexPtr(w io.Writer, p uintptr, isPointer bool) {
-	// Null pointer.
-	num := uint64(p)
-	if num == 0 {
-		if isPointer {
-			w.Write(nilBytes)
+// appendByteComment appends the ASCII annotation b of a hexdump line and
+// the line's newline to dst.
+func appendByteComment(dst, b []byte) []byte {
+	dst = append(dst, " // |"...)
+	dst = append(dst, b...)
+	return append(dst, "|\n"...)
+}
+
+// appendPaddedHex appends val to dst as a hexadecimal literal with its
+// digits zero padded to width.
+func appendPaddedHex(dst []byte, val uint64, width int) []byte {
+	dst = append(dst, hexZeroBytes...)
+	digits := 1
+	for v := val >> 4; v != 0; v >>= 4 {
+		digits++
+	}
+	for ; digits < width; digits++ {
+		dst = append(dst, '0')
+	}
+	return strconv.AppendUint(dst, val, 16)
+}
+
+// isMostlyText returns whether at least three quarters of the bytes in b
+// are part of printable UTF-8 encoded runes or white space.
+func isMostlyText(b []byte) bool {
+	var text int
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if (r != utf8.RuneError || size != 1) && (unicode.IsPrint(r) || unicode.IsSpace(r)) {
+			text += size
+		}
+		i += size
+	}
+	return 4*text >= 3*len(b)
+}
+
+// categoryNames holds the names of the Unicode general categories in
+// lexical order. The LC category is a union of other categories, so it
+// is excluded.
+var categoryNames = func() []string {
+	var names []string
+	for name := range unicode.Categories {
+		if len(name) == 2 && name != "LC" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}()
+
+// runeCategory returns the name of the Unicode general category of r.
+// Unassigned code points are in the Cn category.
+func runeCategory(r rune) string {
+	for _, name := range categoryNames {
+		if unicode.Is(unicode.Categories[name], r) {
+			return name
+		}
+	}
+	return "Cn"
+}
+
+// runeAnnotation returns the code point and general category of r.
+func runeAnnotation(r rune) string {
+	if !utf8.ValidRune(r) {
+		return "invalid"
+	}
+	return fmt.Sprintf("U+%04X %s", r, runeCategory(r))
+}
+
+// nativeOrder is the byte order of the host.
+var nativeOrder binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// hexDumpWords is a hexDump-like dump of integer slices or arrays that writes
+// the elements of v as hexadecimal words. The ASCII comment annotations show
+// the bytes of each word in the given byte order and the address annotations
+// are element indexes. Each line is built in line before it is written to w,
+// and line is returned for reuse. The visit function is called for each
+// element.
+func hexDumpWords(w io.Writer, line []byte, v reflect.Value, indent []byte, width int, comment, addr bool, order binary.ByteOrder, visit func()) []byte {
+	if width <= 0 {
+		width = 16
+	}
+	size := int(v.Type().Elem().Size())
+	perLine := width / size
+	if perLine < 1 {
+		perLine = 1
+	}
+
+	var signed bool
+	switch v.Type().Elem().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		signed = true
+	}
+	// Each word is written with a leading space, an optional sign, the
+	// '0x' prefix, the digits and a trailing comma.
+	slotWidth := 2*size + 4
+	if signed {
+		slotWidth++
+	}
+
+	var commentBytes, word []byte
+	if comment {
+		commentBytes = make([]byte, perLine*size)
+		word = make([]byte, 8)
+	}
+
+	n := v.Len()
+	addrWidth := (bits.Len(uint(n)) + 3) / 4
+	line = line[:0]
+	for i := 0; i < n; i++ {
+		visit()
+		if i%perLine == 0 {
+			line = append(line, indent...)
+			if addr {
+				line = appendPaddedHex(line, uint64(i), addrWidth)
+				line = append(line, ':', ' ')
+			}
 		} else {
-			w.Write(zeroBytes)
+			line = append(line, ' ')
+		}
+
+		e := v.Index(i)
+		var val uint64
+		if signed {
+			x := e.Int()
+			if x < 0 {
+				line = append(line, '-')
+				val = uint64(-x)
+			} else {
+				line = append(line, '+')
+				val = uint64(x)
+			}
+		} else {
+			val = e.Uint()
+		}
+		line = appendPaddedHex(line, val, 2*size)
+		line = append(line, ',')
+		if comment {
+			u := intBits(e)
+			switch size {
+			case 1:
+				word[0] = byte(u)
+			case 2:
+				order.PutUint16(word, uint16(u))
+			case 4:
+				order.PutUint32(word, uint32(u))
+			case 8:
+				order.PutUint64(word, u)
+			}
+			for j, b := range word[:size] {
+				if b < 32 || b > 126 {
+					b = '.'
+				}
+				commentBytes[(i%perLine)*size+j] = b
+			}
+		}
+
+		if !comment {
+			if i%perLine == perLine-1 || i == n-1 {
+				line = append(line, '\n')
+				w.Write(line)
+				line = line[:0]
+			}
+			continue
+		}
+		if i%perLine == perLine-1 {
+			line = appendByteComment(line, commentBytes)
+			w.Write(line)
+			line = line[:0]
+		} else if i == n-1 {
+			if n > perLine {
+				slots := perLine - i%perLine - 1
+				line = append(line, " /*"...)
+				for j := 0; j < slots*slotWidth-5; j++ {
+					line = append(line, ' ')
+				}
+				line = append(line, "*/"...)
+			}
+			line = appendByteComment(line, commentBytes[:(i%perLine+1)*size])
+			w.Write(line)
+			line = line[:0]
 		}
-		return
 	}
+	return line
+}
 
-	// Max uint64 is 16 bytes in hex + 2 bytes for '0x' prefix
-	buf := make([]byte, 18)
+// appendHexPtr appends a uintptr formatted as hexadecimal with a leading '0x'
+// prefix to dst. Null pointers are appended as nil if isPointer is true and
+// as zero otherwise.
+func appendHexPtr(dst []byte, p uintptr, isPointer bool) []byte {
+	if p == 0 {
+		if isPointer {
+			return append(dst, nilBytes...)
+		}
+		return append(dst, zeroBytes...)
+	}
+	dst = append(dst, hexZeroBytes...)
+	return strconv.AppendUint(dst, uint64(p), 16)
+}
+
+// symbolTable holds the names of constant values of a named integer type.
+type symbolTable struct {
+	// flags indicates the values are bit flags.
+	flags bool
+
+	// names maps the bits of constant values to their names.
+	names map[uint64]string
 
-	// It's simpler to construct the hex string right to left.
-	base := uint64(16)
-	i := len(buf) - 1
-	for num >= base {
-		buf[i] = hexDigits[num%base]
-		num /= base
-		i--
+	// values holds the registered values in descending order.
+	values []uint64
+}
+
+// isInteger returns whether the kind is an integer kind.
+func isInteger(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr:
+		return true
+	default:
+		return false
 	}
-	buf[i] = hexDigits[num]
+}
+
+// intBits returns the bits of the integer value v.
+func intBits(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int())
+	default:
+		return v.Uint()
+	}
+}
+
+// hasTagOption returns whether the utter struct tag in tag includes the
+// comma separated option opt.
+func hasTagOption(tag reflect.StructTag, opt string) bool {
+	for opts := tag.Get("utter"); opts != ""; {
+		o := opts
+		opts = ""
+		if i := strings.IndexByte(o, ','); i >= 0 {
+			o, opts = o[:i], o[i+1:]
+		}
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
 
-	// Add '0x' prefix.
-	i--
-	buf[i] = 'x'
-	i--
-	buf[i] = '0'
+// sortedIndexes returns the indexes of the elements of the slice v in
+// the order of their values. Elements with equal values retain their
+// relative order.
+func sortedIndexes(v reflect.Value) []int {
+	order := make([]int, v.Len())
+	for i := range order {
+		order[i] = i
+	}
+	var c comparer
+	sort.SliceStable(order, func(i, j int) bool {
+		return c.compare(v.Index(order[i]), v.Index(order[j])) < 0
+	})
+	return order
+}
 
-	// Strip unused leading bytes.
-	buf = buf[i:]
-	w.Write(buf)
+// permute returns a copy of the slice v with the elements in the order
+// given by the indexes in order.
+func permute(v reflect.Value, order []int) reflect.Value {
+	if !v.CanInterface() {
+		v = unsafeReflectValue(v)
+	}
+	p := reflect.MakeSlice(v.Type(), len(order), len(order))
+	for i, j := range order {
+		p.Index(i).Set(v.Index(j))
+	}
+	return p
 }
 
 // mapSorter implements sort.Interface to allow a slice of reflect.Value
 // elements to be sorted.
 type mapSorter struct {
-	keys []reflect.Value
-	vals []reflect.Value
+	keys    []reflect.Value
+	vals    []reflect.Value
+	keyLess func(a, b reflect.Value) bool
+	c       comparer
 }
 
 // Len returns the number of values in the slice.  It is part of the
@@ -347,58 +1003,296 @@ func (s *mapSorter) Swap(i, j int) {
 	s.vals[i], s.vals[j] = s.vals[j], s.vals[i]
 }
 
-// less returns whether the a key/value should sort before the b key/value.
-// It is used by valueSorter.Less as part of the sort.Interface implementation.
-func less(kA, kB, vA, vB reflect.Value) bool {
-	switch kA.Kind() {
+// comparer holds the state of the structural comparisons made while sorting
+// values. The zero value is ready to use.
+type comparer struct {
+	// visited holds the pairs of references that are being
+	// compared in order to guard against cycles.
+	visited map[visit]bool
+
+	// names caches the names of funcs by their code pointer.
+	names map[uintptr]string
+
+	// keys caches the sorted keys of maps by their pointer.
+	// Maps are not altered while a comparer is in use.
+	keys map[uintptr][]reflect.Value
+}
+
+// visit is a pair of references being compared by a comparer.
+type visit struct {
+	a, b uintptr
+	typ  reflect.Type
+}
+
+// compare returns -1, 0 or +1 depending on whether a sorts before, the same
+// as or after b. The ordering is structural and total over all kinds:
+// invalid and nil values sort first, floating point NaN values sort before
+// other values, arrays, slices and strings are ordered lexicographically,
+// structs are ordered field-wise, maps are ordered by length and then by
+// their sorted entries, interfaces are ordered by dynamic type and then by
+// value, pointers are ordered by their pointees and funcs are ordered by
+// name. Values that can only be distinguished by address are ordered by
+// address. Pairs of pointers, slices or maps that are already being compared
+// are considered equal.
+func (c *comparer) compare(a, b reflect.Value) int {
+	switch {
+	case !a.IsValid() || !b.IsValid():
+		return compareBool(a.IsValid(), b.IsValid())
+	case a.Type() != b.Type():
+		if o := strings.Compare(a.Type().String(), b.Type().String()); o != 0 {
+			return o
+		}
+		return compareUint(uint64(a.Kind()), uint64(b.Kind()))
+	}
+
+	switch a.Kind() {
 	case reflect.Bool:
-		return !kA.Bool() && kB.Bool()
+		return compareBool(a.Bool(), b.Bool())
+
 	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
-		return kA.Int() < kB.Int()
-	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
-		return kA.Uint() < kB.Uint()
+		x, y := a.Int(), b.Int()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Uintptr:
+		return compareUint(a.Uint(), b.Uint())
+
 	case reflect.Float32, reflect.Float64:
-		if vA.IsValid() && vB.IsValid() && math.IsNaN(kA.Float()) && math.IsNaN(kB.Float()) {
-			return less(vA, vB, reflect.Value{}, reflect.Value{})
+		return compareFloat(a.Float(), b.Float())
+
+	case reflect.Complex64, reflect.Complex128:
+		x, y := a.Complex(), b.Complex()
+		if o := compareFloat(real(x), real(y)); o != 0 {
+			return o
 		}
-		return math.IsNaN(kA.Float()) || kA.Float() < kB.Float()
+		return compareFloat(imag(x), imag(y))
+
 	case reflect.String:
-		return kA.String() < kB.String()
-	case reflect.Uintptr:
-		return kA.Uint() < kB.Uint()
+		return strings.Compare(a.String(), b.String())
+
 	case reflect.Array:
-		// Compare the contents of both arrays.
-		l := kA.Len()
-		for i := 0; i < l; i++ {
-			av := kA.Index(i)
-			bv := kB.Index(i)
-			if av.Interface() == bv.Interface() {
-				continue
+		for i := 0; i < a.Len(); i++ {
+			if o := c.compare(a.Index(i), b.Index(i)); o != 0 {
+				return o
+			}
+		}
+		return 0
+
+	case reflect.Slice:
+		if a.IsNil() || b.IsNil() {
+			return compareBool(!a.IsNil(), !b.IsNil())
+		}
+		if a.Pointer() == b.Pointer() && a.Len() == b.Len() {
+			return 0
+		}
+		return c.compareRefs(a, b, (*comparer).compareElems)
+
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if o := c.compare(a.Field(i), b.Field(i)); o != 0 {
+				return o
 			}
-			return less(av, bv, vA, vB)
 		}
-		return less(vA, vB, reflect.Value{}, reflect.Value{})
+		return 0
+
+	case reflect.Map:
+		if a.IsNil() || b.IsNil() {
+			return compareBool(!a.IsNil(), !b.IsNil())
+		}
+		if a.Pointer() == b.Pointer() {
+			return 0
+		}
+		return c.compareRefs(a, b, (*comparer).compareEntries)
+
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return compareBool(!a.IsNil(), !b.IsNil())
+		}
+		return c.compare(a.Elem(), b.Elem())
+
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return compareBool(!a.IsNil(), !b.IsNil())
+		}
+		if a.Pointer() == b.Pointer() {
+			return 0
+		}
+		return c.compareRefs(a, b, (*comparer).comparePointees)
+
+	case reflect.Func:
+		if a.IsNil() || b.IsNil() {
+			return compareBool(!a.IsNil(), !b.IsNil())
+		}
+		if a.Pointer() == b.Pointer() {
+			return 0
+		}
+		if o := strings.Compare(c.funcName(a.Pointer()), c.funcName(b.Pointer())); o != 0 {
+			return o
+		}
+		return compareUint(uint64(a.Pointer()), uint64(b.Pointer()))
+
+	default:
+		// Channels and unsafe pointers can only be distinguished
+		// by address.
+		return compareUint(uint64(a.Pointer()), uint64(b.Pointer()))
 	}
-	return fmt.Sprint(kA) < fmt.Sprint(kB)
+}
+
+// compareRefs returns the order of the values referred to by the non-nil
+// pointers, slices or maps a and b of the same type as given by cmp. If a
+// and b are already being compared, they are considered equal.
+func (c *comparer) compareRefs(a, b reflect.Value, cmp func(c *comparer, a, b reflect.Value) int) int {
+	v := visit{a.Pointer(), b.Pointer(), a.Type()}
+	if c.visited[v] {
+		return 0
+	}
+	if c.visited == nil {
+		c.visited = make(map[visit]bool)
+	}
+	c.visited[v] = true
+	order := cmp(c, a, b)
+	delete(c.visited, v)
+	return order
+}
+
+// comparePointees returns the order of the values pointed to by a and b.
+func (c *comparer) comparePointees(a, b reflect.Value) int {
+	return c.compare(a.Elem(), b.Elem())
+}
+
+// compareElems returns the lexicographic order of the slices a and b.
+func (c *comparer) compareElems(a, b reflect.Value) int {
+	for i := 0; i < a.Len() && i < b.Len(); i++ {
+		if o := c.compare(a.Index(i), b.Index(i)); o != 0 {
+			return o
+		}
+	}
+	return compareUint(uint64(a.Len()), uint64(b.Len()))
+}
+
+// compareEntries returns the order of the maps a and b by length and then
+// by their sorted entries.
+func (c *comparer) compareEntries(a, b reflect.Value) int {
+	if o := compareUint(uint64(a.Len()), uint64(b.Len())); o != 0 {
+		return o
+	}
+	aKeys := c.sortedKeys(a)
+	bKeys := c.sortedKeys(b)
+	for i := range aKeys {
+		if o := c.compare(aKeys[i], bKeys[i]); o != 0 {
+			return o
+		}
+	}
+	for i := range aKeys {
+		if o := c.compare(a.MapIndex(aKeys[i]), b.MapIndex(bKeys[i])); o != 0 {
+			return o
+		}
+	}
+	return 0
+}
+
+// sortedKeys returns the keys of the map m in the order given by compare,
+// sorting them only once for each map.
+func (c *comparer) sortedKeys(m reflect.Value) []reflect.Value {
+	keys, ok := c.keys[m.Pointer()]
+	if ok {
+		return keys
+	}
+	keys = m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return c.compare(keys[i], keys[j]) < 0
+	})
+	if c.keys == nil {
+		c.keys = make(map[uintptr][]reflect.Value)
+	}
+	c.keys[m.Pointer()] = keys
+	return keys
+}
+
+// funcName returns the name of the func at pc, resolving it only once for
+// each pc.
+func (c *comparer) funcName(pc uintptr) string {
+	name, ok := c.names[pc]
+	if !ok {
+		if c.names == nil {
+			c.names = make(map[uintptr]string)
+		}
+		name = funcName(pc, nil)
+		c.names[pc] = name
+	}
+	return name
+}
+
+// compareBool returns the order of a and b with false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case b:
+		return -1
+	}
+	return 1
+}
+
+// compareUint returns the order of a and b.
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareFloat returns the order of a and b with NaN values before all
+// other values.
+func compareFloat(a, b float64) int {
+	switch aNaN, bNaN := math.IsNaN(a), math.IsNaN(b); {
+	case aNaN || bNaN:
+		return compareBool(!aNaN, !bNaN)
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
 }
 
 // Less returns whether the value at index i should sort before the
 // value at index j.  It is part of the sort.Interface implementation.
 func (s *mapSorter) Less(i, j int) bool {
-	return less(s.keys[i], s.keys[j], s.vals[i], s.vals[j])
+	if s.keyLess != nil {
+		switch {
+		case s.keyLess(s.keys[i], s.keys[j]):
+			return true
+		case s.keyLess(s.keys[j], s.keys[i]):
+			return false
+		}
+	}
+	// Keys that compare equal are ordered by their values.
+	if c := s.c.compare(s.keys[i], s.keys[j]); c != 0 {
+		return c < 0
+	}
+	return s.c.compare(s.vals[i], s.vals[j]) < 0
 }
 
-// sortMapByKeyVals is a generic sort function for native types: int, uint, bool,
-// float, string and uintptr.  Other inputs are sorted according to their
-// Value.String() value to ensure display stability. Floating point values
-// sort NaN before non-NaN values and NaN keys are ordered by their corresponding
-// values.
-func sortMapByKeyVals(keys, vals []reflect.Value) {
+// sortMapByKeyVals sorts keys and their corresponding vals by the structural
+// ordering of comparer.compare. Keys that compare equal, such as floating point NaN
+// values, are ordered by their corresponding values. If keyLess is not nil,
+// it is consulted first and the structural ordering is only used for keys
+// that keyLess does not order.
+func sortMapByKeyVals(keys, vals []reflect.Value, keyLess func(a, b reflect.Value) bool) {
 	if len(keys) != len(vals) {
 		panic("invalid map key val slice pair")
 	}
 	if len(keys) == 0 {
 		return
 	}
-	sort.Sort(&mapSorter{keys: keys, vals: vals})
+	sort.Sort(&mapSorter{keys: keys, vals: vals, keyLess: keyLess})
 }
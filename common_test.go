@@ -19,6 +19,7 @@ package utter_test
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"testing"
 
@@ -69,6 +70,12 @@ type embed struct {
 	a string
 }
 
+// pair is used to test structural sorting.
+type pair struct {
+	n int
+	s string
+}
+
 // embedwrap is used to test embedded structures.
 type embedwrap struct {
 	*embed
@@ -134,6 +141,8 @@ func TestSortValues(t *testing.T) {
 	embedA := v(embed{"a"})
 	embedB := v(embed{"b"})
 	embedC := v(embed{"c"})
+	one, three := 1, 3
+	ifaces := v([]interface{}{"b", "a", 1, nil})
 	tests := []struct {
 		input    []reflect.Value
 		expected []reflect.Value
@@ -178,6 +187,26 @@ func TestSortValues(t *testing.T) {
 			[]reflect.Value{v(uintptr(2)), v(uintptr(1)), v(uintptr(3))},
 			[]reflect.Value{v(uintptr(1)), v(uintptr(2)), v(uintptr(3))},
 		},
+		// Structs.
+		{
+			[]reflect.Value{v(pair{2, "a"}), v(pair{1, "b"}), v(pair{1, "a"})},
+			[]reflect.Value{v(pair{1, "a"}), v(pair{1, "b"}), v(pair{2, "a"})},
+		},
+		// Slices.
+		{
+			[]reflect.Value{v([]int{1, 2}), v([]int(nil)), v([]int{1})},
+			[]reflect.Value{v([]int(nil)), v([]int{1}), v([]int{1, 2})},
+		},
+		// Pointers.
+		{
+			[]reflect.Value{v(&three), v((*int)(nil)), v(&one)},
+			[]reflect.Value{v((*int)(nil)), v(&one), v(&three)},
+		},
+		// Interfaces.
+		{
+			[]reflect.Value{ifaces.Index(0), ifaces.Index(1), ifaces.Index(2), ifaces.Index(3)},
+			[]reflect.Value{ifaces.Index(3), ifaces.Index(2), ifaces.Index(1), ifaces.Index(0)},
+		},
 		// Invalid.
 		{
 			[]reflect.Value{embedB, embedA, embedC},
@@ -203,3 +232,43 @@ func TestSortValues(t *testing.T) {
 		}
 	}
 }
+
+// TestSortCycles ensures that sorting values that refer to themselves through
+// slices, maps and interfaces terminates.
+func TestSortCycles(t *testing.T) {
+	cfg := utter.NewDefaultConfig()
+	cfg.SortKeys = true
+	cfg.SortSlices = true
+
+	self := []interface{}{nil, nil}
+	self[0], self[1] = self, self
+
+	a := []interface{}{nil, 2}
+	b := []interface{}{nil, 1}
+	a[0], b[0] = b, a
+
+	// Keys that compare equal are ordered by their values.
+	m1 := map[string]interface{}{}
+	m2 := map[string]interface{}{"x": m1}
+	m1["x"] = m2
+	nan := map[float64]interface{}{math.NaN(): m1, math.NaN(): m2}
+
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{self, "[]interface{}{\n []interface{}(<already shown>),\n []interface{}(<already shown>),\n}\n"},
+		{[]interface{}{a, b}, "[]interface{}{\n" +
+			" []interface{}{\n  []interface{}{\n   []interface{}(<already shown>),\n   int(1),\n  },\n  int(2),\n },\n" +
+			" []interface{}{\n  []interface{}(<already shown>),\n  int(1),\n },\n}\n"},
+		{nan, "map[float64]interface{}{\n" +
+			" float64(NaN): map[string]interface{}{\n  string(\"x\"): map[string]interface{}{\n" +
+			"   string(\"x\"): map[string]interface{}(<already shown>),\n  },\n },\n" +
+			" float64(NaN): map[string]interface{}{\n  string(\"x\"): map[string]interface{}(<already shown>),\n },\n}\n"},
+	}
+	for i, test := range tests {
+		if got := cfg.Sdump(test.in); got != test.want {
+			t.Errorf("test %d: unexpected dump:\ngot:\n%s\nwant:\n%s", i, got, test.want)
+		}
+	}
+}
@@ -19,8 +19,12 @@ package utter
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"io"
 	"os"
+	"reflect"
+	"sort"
 )
 
 // ConfigState houses the configuration options used by utter to format and
@@ -50,6 +54,47 @@ type ConfigState struct {
 	// a string slice or array. Zero specifies all entries on one line.
 	StringWidth int
 
+	// SignedRadix specifies the base used to print signed integer values.
+	// Valid values are 2, 8, 10 and 16. If this is not set or invalid, a
+	// value of 10 is used.
+	SignedRadix int
+
+	// UnsignedRadix specifies the base used to print unsigned integer
+	// values. Valid values are 2, 8, 10 and 16. If this is not set or
+	// invalid, a value of 16 is used.
+	UnsignedRadix int
+
+	// TypeRadix specifies the base used to print integer values of
+	// specific types, overriding SignedRadix and UnsignedRadix.
+	TypeRadix map[reflect.Type]int
+
+	// GroupDigits specifies that the digits of integer values are
+	// separated into groups by underscores; groups of four digits for
+	// binary and hexadecimal and groups of three for octal and decimal.
+	GroupDigits bool
+
+	// PadDigits specifies that binary, octal and hexadecimal integer
+	// values are zero padded to the width of their type.
+	PadDigits bool
+
+	// FloatSpecials specifies that NaN, infinite and negative zero floating
+	// point values are printed as calls to math package functions so that
+	// they are valid Go expressions.
+	FloatSpecials bool
+
+	// HexFloats specifies that floating point values are printed as exact
+	// hexadecimal floating point literals. NaN, infinite and negative zero
+	// values are printed as for FloatSpecials, except that NaN values are
+	// printed as conversions from their bit pattern.
+	HexFloats bool
+
+	// ComplexCalls specifies that complex values with NaN, infinite or
+	// negative zero parts are printed as calls to the complex builtin so
+	// that they are valid Go expressions. The parts are printed as for
+	// FloatSpecials, or HexFloats if it is set, which also implies
+	// ComplexCalls.
+	ComplexCalls bool
+
 	// Quoting specifies the quoting strategy to use when printing strings.
 	Quoting Quoting
 
@@ -66,30 +111,216 @@ type ConfigState struct {
 	// annotations.
 	AddressBytes bool
 
+	// ByteEncoding specifies how non-empty byte slices are rendered.
+	// Byte arrays are always rendered as a hexdump.
+	ByteEncoding ByteEncoding
+
+	// GzipThreshold specifies the length at or above which byte slices
+	// are compressed when ByteEncoding is GzipBytes. If this is not set
+	// or negative, a value of 1024 is used.
+	GzipThreshold int
+
+	// HexWords specifies whether slices and arrays of integer types wider
+	// than a byte are dumped as a grid of hexadecimal words. The grid
+	// uses BytesWidth bytes per line and the CommentBytes and AddressBytes
+	// annotations. Addresses are element indexes. Slices of named integer
+	// types, and of int32 when CommentRunes is set, are not dumped as words.
+	HexWords bool
+
+	// ByteOrder specifies the byte order used to render the ASCII comment
+	// annotations of HexWords dumps. If ByteOrder is nil, the byte order
+	// of the host is used.
+	ByteOrder binary.ByteOrder
+
+	// CommentLengths specifies whether slices are annotated with their
+	// length and capacity, and maps and strings longer than 64 bytes are
+	// annotated with their length.
+	CommentLengths bool
+
+	// CommentSpare specifies whether the elements of a slice between its
+	// length and capacity are included as line comments. Spare elements
+	// are not shown for slices dumped on a single line.
+	CommentSpare bool
+
+	// CommentRunes specifies whether strings and multi-line slices of
+	// int32 are annotated with the code point and Unicode general
+	// category of each rune. Invalid UTF-8 bytes in strings and invalid
+	// runes are flagged.
+	CommentRunes bool
+
+	// ChanContents specifies how the elements queued in buffered channels
+	// are rendered.
+	ChanContents ChanContents
+
 	// CommentPointers specifies whether pointer information will be added
 	// as comments.
 	CommentPointers bool
 
+	// PointerStyle specifies how pointers are rendered where applying the
+	// address-of operator to the dumped value would not be valid Go; for
+	// pointers to values that are not composite literals and for multiple
+	// levels of indirection.
+	PointerStyle PointerStyle
+
+	// MaxNodes specifies the maximum number of values visited by a dump.
+	// Dumps that exceed the budget are aborted with a trailing comment.
+	// When CommentPointers is set, the budget also applies to the pass
+	// that finds the pointers shared within the value. Zero specifies no
+	// limit.
+	MaxNodes int
+
+	// MaxBytes specifies the maximum number of bytes written by a dump
+	// before it is aborted with a trailing comment, which is not counted.
+	// Output held for alignment, commenting or formatting is counted when
+	// it is rendered, and as much of it as fits is written when the dump
+	// is aborted. Zero specifies no limit.
+	MaxBytes int
+
+	// Gofmt specifies whether dumps are made syntactically valid Go and
+	// formatted with gofmt. Values that have already been shown are
+	// rendered as nil with an explanatory comment, func and channel types
+	// are parenthesized and the capacity of buffered channels is given in
+	// a comment. Output is indented with tabs and the Indent option is
+	// ignored. Dumps that cannot be formatted are written unformatted
+	// followed by a comment giving the reason, which is returned by
+	// FdumpErr and FdumpContext.
+	Gofmt bool
+
+	// AlignFields specifies whether the values of struct fields and map
+	// entries written on a single line are aligned in a column in the
+	// same way that gofmt aligns the elements of composite literals.
+	// Trailing comments are not aligned.
+	AlignFields bool
+
+	// CommentLayout specifies whether struct dumps are annotated with the
+	// size and alignment of the struct and the offset and size of each of
+	// its fields. Padding between fields is also annotated.
+	CommentLayout bool
+
+	// FuncNames specifies that non-nil func values are printed as the
+	// package qualified name of the function rather than its address.
+	// Closures, method values and instantiations of generic functions,
+	// which are named without their type arguments, are annotated as such.
+	FuncNames bool
+
 	// IgnoreUnexported specifies that unexported struct fields should be
 	// ignored during a dump.
 	IgnoreUnexported bool
 
+	// UnexportedFields specifies how unexported fields of struct types
+	// declared outside the package given by TargetPackage or LocalPackage
+	// are rendered. These fields cannot be set by a composite literal in
+	// that package, so omitting them or moving them into a comment allows
+	// the dump to be compiled there. IgnoreUnexported takes precedence.
+	UnexportedFields UnexportedFields
+
 	// OmitZero specifies that zero values should not be printed in a dump.
 	OmitZero bool
 
-	// LocalPackage specifies a package selector to trim from type information.
+	// LocalPackage specifies the name of a package whose identifiers are
+	// printed without a package selector. TargetPackage should be preferred
+	// since package names are not unique. The name must match the package
+	// name exactly, ignoring any trailing dot; earlier versions trimmed
+	// LocalPackage as a prefix of type names.
 	LocalPackage string
 
+	// TargetPackage specifies the import path of the package that the dump
+	// is written for. Identifiers from that package are printed without a
+	// package selector and packages from other import paths that share a
+	// name are given distinct aliases.
+	TargetPackage string
+
 	// ElideType specifies that type information defined by context should
 	// not be printed in a dump.
 	ElideType bool
 
+	// StringerConstants specifies that values of named integer types
+	// that implement fmt.Stringer are printed as the package qualified
+	// String result when that is an exported Go identifier, or any Go
+	// identifier for types whose package is not qualified. This is a
+	// heuristic; the String result is not checked to be the name of a
+	// constant with the value, so the dump may not compile. Values with
+	// a name registered by RegisterEnum or RegisterFlags are printed
+	// using the registered name, which should be preferred.
+	StringerConstants bool
+
 	// SortKeys specifies map keys should be sorted before being printed. Use
-	// this to have a more deterministic, diffable output.  Note that only
-	// native types (bool, int, uint, floats, uintptr and string) are supported
-	// with other types sorted according to the reflect.Value.String() output
-	// which guarantees display stability.
+	// this to have a more deterministic, diffable output.  Keys are ordered
+	// structurally: structs field-wise, arrays lexicographically, interfaces
+	// by dynamic type and then value, and pointers by their pointees. Only
+	// channel and unsafe pointer keys are ordered by address.
 	SortKeys bool
+
+	// KeyLess, if not nil, is used to order map keys when SortKeys is
+	// set. KeyLess reports whether the key a should sort before the key
+	// b. Keys that are not ordered by KeyLess in either direction are
+	// ordered by the built-in ordering. The keys passed to KeyLess may
+	// have been obtained from unexported fields, so their Interface
+	// method may panic.
+	KeyLess func(a, b reflect.Value) bool
+
+	// SortSlices specifies slice elements should be sorted before being
+	// printed. Elements are ordered using the same comparison as SortKeys.
+	// Individual struct fields may be sorted by giving them a struct tag
+	// of `utter:"sort"`. The dumped slices are not altered. Byte slices
+	// rendered with a ByteEncoding other than HexDumpBytes and rune slices
+	// annotated by CommentRunes are not sorted since their order is part
+	// of the text they hold.
+	SortSlices bool
+
+	// symbols holds the constant names registered by RegisterEnum
+	// and RegisterFlags.
+	symbols map[reflect.Type]*symbolTable
+}
+
+// RegisterEnum registers the names of constant values of a named integer
+// type so that dumped values of the type that match a registered value are
+// printed as the package qualified constant name. The names parameter must
+// be a map with the named integer type as its key and the unqualified
+// constant names as values, for example
+//
+//	map[http.ConnState]string{http.StateNew: "StateNew", http.StateActive: "StateActive"}
+//
+// RegisterEnum panics if names is not a map of this form. It must not be
+// called concurrently with dumps using c.
+func (c *ConfigState) RegisterEnum(names interface{}) {
+	c.register(names, false)
+}
+
+// RegisterFlags registers the names of bit flag constant values of a named
+// integer type so that dumped values of the type are printed as a
+// combination of the package qualified constant names, for example
+// os.ModeDir|os.ModeSymlink. Any bits that are not covered by a registered
+// flag are printed numerically. The names parameter has the same form as
+// for RegisterEnum.
+//
+// RegisterFlags panics if names is not a map of this form. It must not be
+// called concurrently with dumps using c.
+func (c *ConfigState) RegisterFlags(names interface{}) {
+	c.register(names, true)
+}
+
+func (c *ConfigState) register(names interface{}, flags bool) {
+	rv := reflect.ValueOf(names)
+	if rv.Kind() != reflect.Map || rv.Type().Elem().Kind() != reflect.String {
+		panic("utter: constant names must be a map of named integers to strings")
+	}
+	typ := rv.Type().Key()
+	if typ.PkgPath() == "" || !isInteger(typ.Kind()) {
+		panic("utter: constant names must be a map of named integers to strings")
+	}
+	table := &symbolTable{flags: flags, names: make(map[uint64]string)}
+	iter := rv.MapRange()
+	for iter.Next() {
+		val := intBits(iter.Key())
+		table.names[val] = iter.Value().String()
+		table.values = append(table.values, val)
+	}
+	sort.Slice(table.values, func(i, j int) bool { return table.values[i] > table.values[j] })
+	if c.symbols == nil {
+		c.symbols = make(map[reflect.Type]*symbolTable)
+	}
+	c.symbols[typ] = table
 }
 
 // Quoting describes string quoting strategies.
@@ -113,6 +344,97 @@ const (
 	// Force is a modifier of AvoidEscapes that adds additional double
 	// quote syntax to represent parts that cannot be backquoted.
 	Force
+
+	// Multiline is a modifier that splits double quoted strings or
+	// parts of strings after each newline, rendering them as a
+	// concatenation with one line per continuation.
+	Multiline
+)
+
+// ByteEncoding describes byte slice rendering strategies.
+//
+// The numerical values of byte encoding constants are not guaranteed to be stable.
+type ByteEncoding uint
+
+const (
+	// HexDumpBytes renders byte slices as a hexdump.
+	HexDumpBytes ByteEncoding = iota
+
+	// StringBytes renders byte slices as a conversion of a string
+	// literal when most of the bytes are printable UTF-8 text.
+	// Other byte slices are rendered as a hexdump.
+	StringBytes
+
+	// Base64Bytes renders byte slices as a conversion of a call
+	// to MustBase64.
+	Base64Bytes
+
+	// GzipBytes renders byte slices at or above GzipThreshold in
+	// length as a conversion of a call to MustGzipBase64. Shorter
+	// byte slices are rendered as for Base64Bytes.
+	GzipBytes
+)
+
+// ChanContents describes buffered channel content rendering strategies.
+//
+// The numerical values of channel contents constants are not guaranteed to be stable.
+type ChanContents uint
+
+const (
+	// OmitChanContents does not render queued channel elements.
+	OmitChanContents ChanContents = iota
+
+	// CommentChanContents renders queued channel elements in a comment
+	// following the channel's address.
+	CommentChanContents
+
+	// ConstructChanContents renders buffered channels as a call to a
+	// function literal that makes the channel and sends the queued
+	// elements to it.
+	ConstructChanContents
+)
+
+// UnexportedFields describes rendering strategies for unexported struct
+// fields of types declared outside the target package of a dump.
+//
+// The numerical values of unexported fields constants are not guaranteed to be stable.
+type UnexportedFields uint
+
+const (
+	// ShowUnexported renders unexported fields in the same way as
+	// exported fields.
+	ShowUnexported UnexportedFields = iota
+
+	// OmitForeignUnexported does not render unexported fields
+	// declared outside the target package. If neither TargetPackage
+	// nor LocalPackage is set, the fields are rendered as for
+	// CommentForeignUnexported so that the loss of data is visible.
+	OmitForeignUnexported
+
+	// CommentForeignUnexported renders unexported fields declared
+	// outside the target package in a comment following the other
+	// fields of the struct.
+	CommentForeignUnexported
+)
+
+// PointerStyle describes pointer rendering strategies.
+//
+// The numerical values of pointer style constants are not guaranteed to be stable.
+type PointerStyle uint
+
+const (
+	// AddressOf renders pointers by applying address-of operators
+	// to the dumped value, for example &int(5), even where this is
+	// not valid Go.
+	AddressOf PointerStyle = iota
+
+	// PtrHelper renders pointers as calls to Ptr, for example
+	// utter.Ptr(int(5)).
+	PtrHelper
+
+	// PtrClosure renders pointers as a call to a function literal
+	// that returns the address of a variable holding the value.
+	PtrClosure
 )
 
 // Config is the active configuration of the top-level functions.
@@ -131,6 +453,23 @@ func (c *ConfigState) Fdump(w io.Writer, a interface{}) {
 	fdump(c, w, a)
 }
 
+// FdumpErr formats and displays the passed arguments to io.Writer w in the
+// same way as Fdump. The dump is stopped at the first error writing to w,
+// and the error is returned. If the Gofmt option is set and the dump cannot
+// be formatted, the formatting error is returned.
+func (c *ConfigState) FdumpErr(w io.Writer, a interface{}) error {
+	return fdumpQualified(context.Background(), c, w, a, newQualifier(c.TargetPackage, c.LocalPackage))
+}
+
+// FdumpContext formats and displays the passed arguments to io.Writer w
+// in the same way as Fdump. The dump is aborted if ctx is cancelled or the
+// dump exceeds the MaxNodes or MaxBytes budgets. When a dump is aborted,
+// a comment explaining why is written and the reason is returned. Errors
+// writing to w are returned as for FdumpErr.
+func (c *ConfigState) FdumpContext(ctx context.Context, w io.Writer, a interface{}) error {
+	return fdumpQualified(ctx, c, w, a, newQualifier(c.TargetPackage, c.LocalPackage))
+}
+
 /*
 Dump displays the passed parameters to standard out with newlines, customizable
 indentation, and additional debug information such as complete types and all
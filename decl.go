@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2015 Dan Kortschak <dan.kortschak@adelaide.edu.au>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// fdecl writes the dump of a as the value of a variable declaration with
+// the given name to w. The declaration is preceded by the imports needed
+// by the dump, and if pkg is not empty, by a package clause.
+func fdecl(cs *ConfigState, w io.Writer, pkg, name string, a interface{}) {
+	c := *cs
+	c.Gofmt = true
+
+	q := newQualifier(c.TargetPackage, c.LocalPackage)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "var %s = ", name)
+	fdumpQualified(context.Background(), &c, &buf, a, q)
+	decl := buf.Bytes()
+
+	var src bytes.Buffer
+	clause := pkg
+	if clause == "" {
+		clause = "p"
+	}
+	fmt.Fprintf(&src, "package %s\n\n", clause)
+	prefix := src.Len()
+	writeImports(&src, importPaths(decl, q), q)
+	src.Write(decl)
+
+	b, err := format.Source(src.Bytes())
+	if err != nil {
+		b = src.Bytes()
+	}
+	if pkg == "" {
+		b = b[prefix:]
+	}
+	w.Write(b)
+}
+
+// importPaths returns the import paths of the packages referred to by the
+// variable declaration decl. Package names are resolved using q.
+func importPaths(decl []byte, q *qualifier) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "", append([]byte("package p\n\n"), decl...), 0)
+	if err != nil {
+		return nil
+	}
+	used := make(map[string]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		path, ok := q.used[id.Name]
+		if ok && path != "" && path != "main" {
+			used[path] = true
+		}
+		return true
+	})
+	paths := make([]string, 0, len(used))
+	for path := range used {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		si, sj := isStdlib(paths[i]), isStdlib(paths[j])
+		if si != sj {
+			return si
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+// isStdlib returns whether the import path is in the standard library.
+func isStdlib(path string) bool {
+	return stdlibPaths[path]
+}
+
+// writeImports writes an import declaration for paths to w, with standard
+// library packages grouped before other packages. Packages are given the
+// alias used for them by q if they have one.
+func writeImports(w io.Writer, paths []string, q *qualifier) {
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "import (")
+	for i, path := range paths {
+		if i > 0 && isStdlib(paths[i-1]) && !isStdlib(path) {
+			fmt.Fprintln(w)
+		}
+		if alias := q.alias(path); alias != "" {
+			fmt.Fprintf(w, "\t%s %s\n", alias, strconv.Quote(path))
+		} else {
+			fmt.Fprintf(w, "\t%s\n", strconv.Quote(path))
+		}
+	}
+	fmt.Fprint(w, ")\n\n")
+}
+
+// SdumpDecl returns a variable declaration with the given name whose value
+// is the dump of a, preceded by an import declaration for the packages that
+// the dump refers to. The dump is made syntactically valid and is formatted
+// as described for the Gofmt option.
+func (c *ConfigState) SdumpDecl(name string, a interface{}) string {
+	var buf bytes.Buffer
+	fdecl(c, &buf, "", name, a)
+	return buf.String()
+}
+
+// SdumpFile returns a Go source file for the package pkg holding the
+// declaration returned by SdumpDecl.
+func (c *ConfigState) SdumpFile(pkg, name string, a interface{}) string {
+	var buf bytes.Buffer
+	fdecl(c, &buf, pkg, name, a)
+	return buf.String()
+}
+
+// SdumpDecl returns a variable declaration with the given name whose value
+// is the dump of a, preceded by an import declaration for the packages that
+// the dump refers to. The dump is made syntactically valid and is formatted
+// as described for the Gofmt option.
+func SdumpDecl(name string, a interface{}) string {
+	return Config.SdumpDecl(name, a)
+}
+
+// SdumpFile returns a Go source file for the package pkg holding the
+// declaration returned by SdumpDecl.
+func SdumpFile(pkg, name string, a interface{}) string {
+	return Config.SdumpFile(pkg, name, a)
+}
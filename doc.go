@@ -67,6 +67,32 @@ The following configuration options are available:
 		StringWidth specifies the number of columns to use when dumping
 		a string slice or array. Zero specifies all entries on one line.
 
+	* SignedRadix, UnsignedRadix and TypeRadix
+		Specify the base used to print signed and unsigned integers and
+		integers of specific types; one of 2, 8, 10 or 16. Signed integers
+		are printed in decimal and unsigned integers in hexadecimal by
+		default.
+
+	* GroupDigits
+		Specifies that integer digits are separated into groups by
+		underscores.
+
+	* PadDigits
+		Specifies that binary, octal and hexadecimal integers are zero
+		padded to the width of their type.
+
+	* FloatSpecials
+		Specifies that NaN, infinite and negative zero floating point values
+		are printed as math package function calls.
+
+	* HexFloats
+		Specifies that floating point values are printed as exact
+		hexadecimal floating point literals.
+
+	* ComplexCalls
+		Specifies that complex values with NaN, infinite or negative zero
+		parts are printed as calls to the complex builtin.
+
 	* BytesWidth
 		Number of byte columns to use when dumping byte slices and arrays.
 
@@ -74,25 +100,121 @@ The following configuration options are available:
 		Specifies whether ASCII comment annotations are attached to byte
 		slice and array dumps.
 
+	* ByteEncoding
+		Specifies whether byte slices are rendered as a hexdump, a string
+		literal, base64 or gzip compressed base64 text.
+
+	* GzipThreshold
+		Length at or above which byte slices are compressed when the
+		GzipBytes encoding is used.
+
+	* HexWords
+		Specifies whether slices and arrays of integers wider than a byte
+		are dumped as a grid of hexadecimal words. Named integer types and
+		rune commented slices are not.
+
+	* ByteOrder
+		Byte order used for the ASCII annotations of HexWords dumps.
+
+	* CommentLengths
+		Specifies whether slices are annotated with their length and
+		capacity, and maps and long strings with their length.
+
+	* CommentSpare
+		Specifies whether slice elements between the length and capacity
+		of a slice are included as line comments.
+
+	* CommentRunes
+		Specifies whether strings and rune slices are annotated with the
+		code point and general category of each rune.
+
+	* ChanContents
+		Specifies how elements queued in buffered channels are rendered;
+		not at all, as a comment or as a function call constructing the
+		channel. Channel buffers are read without receiving from them.
+
 	* CommentPointers
 		CommentPointers specifies whether pointer information will be added
 		as comments.
 
+	* PointerStyle
+		Specifies whether pointers to values that are not composite
+		literals are rendered with address-of operators, as calls to the
+		Ptr helper or as calls to function literals.
+
+	* MaxNodes and MaxBytes
+		Specify budgets for the number of values visited and bytes written
+		by a dump. Dumps that exceed a budget are aborted with a trailing
+		comment. Zero specifies no limit.
+
+	* Gofmt
+		Specifies whether dumps are made syntactically valid and are
+		formatted with gofmt. Dumps that cannot be formatted are written
+		unformatted with a trailing comment giving the reason.
+
+	* AlignFields
+		Specifies whether the values of single line struct fields and
+		map entries are aligned in a column as gofmt would align them.
+
+	* CommentLayout
+		Specifies whether struct dumps are annotated with the size and
+		alignment of the struct and the offset and size of each field,
+		including any padding between fields.
+
+	* FuncNames
+		Specifies that func values are printed as the qualified name of
+		the function rather than its address.
+
 	* IgnoreUnexported
 		Specifies that unexported fields should be ignored.
 
+	* LocalPackage
+		Name of a package whose identifiers are printed unqualified. The
+		name must match the package name exactly, ignoring any trailing
+		dot; earlier versions trimmed it as a prefix of type names.
+		TargetPackage should be preferred.
+
+	* TargetPackage
+		Import path of the package the dump is written for. Its identifiers
+		are printed unqualified and other packages sharing a name are given
+		distinct aliases.
+
+	* UnexportedFields
+		Specifies whether unexported fields of types declared outside the
+		target package are shown, omitted or moved into a comment so that
+		the dump compiles in the target package.
+		Fields are only omitted when the target package is known.
+
 	* ElideType
 		ElideType specifies that type information defined by context should
 		not be printed in a dump.
 
+	* StringerConstants
+		Specifies that values of named integer types are printed as the
+		package qualified result of their String method when it is an
+		exported Go identifier, or any Go identifier for types whose
+		package is not qualified. This is a heuristic; the String result
+		is not checked to be the name of a constant with the value, so
+		the dump may not compile. Constant names should be registered for
+		enum and bit flag types with the ConfigState RegisterEnum and
+		RegisterFlags methods where possible.
+
 	* SortKeys
 		Specifies map keys should be sorted before being printed. Use
-		this to have a more deterministic, diffable output.  Note that
-		only native types (bool, int, uint, floats, uintptr and string)
-		are supported with other types sorted according to the
-		reflect.Value.String() output which guarantees display stability.
+		this to have a more deterministic, diffable output.  Keys of all
+		kinds are ordered structurally, with only channels and unsafe
+		pointers ordered by address.
 		Natural map order is used by default.
 
+	* KeyLess
+		Function used to order map keys before the built-in ordering
+		when SortKeys is set.
+
+	* SortSlices
+		Specifies slice elements should be sorted before being printed.
+		Struct fields tagged with `utter:"sort"` are always sorted.
+		Encoded byte slices and rune commented slices are not sorted.
+
 Dump Usage
 
 Simply call utter.Dump with a list of variables you want to dump:
@@ -108,6 +230,22 @@ A third option is to call utter.Sdump to get the formatted output as a string:
 
 	str := utter.Sdump(myVar1)
 
+To find out whether writing a dump failed, call utter.FdumpErr, which stops
+at the first error from the io.Writer and returns it:
+
+	err := utter.FdumpErr(f, myVar1)
+
+To abort a dump when a context is cancelled or the dump exceeds its budget,
+call utter.FdumpContext, which returns the reason the dump was aborted:
+
+	err := utter.FdumpContext(ctx, os.Stderr, myVar1)
+
+To get a variable declaration holding the dump, together with the imports it
+needs, call utter.SdumpDecl, or utter.SdumpFile for a complete Go source file:
+
+	decl := utter.SdumpDecl("want", myVar1)
+	src := utter.SdumpFile("testdata", "want", myVar1)
+
 Sample Dump Output
 
 See the Dump example for details on the setup of the types and variables being
@@ -18,12 +18,19 @@
 package utter
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"go/format"
+	"go/token"
 	"io"
 	"os"
 	"reflect"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"unicode"
@@ -49,20 +56,75 @@ var (
 	cUint8tCharRE = regexp.MustCompile(`^.*\._Ctype_uint8_t$`)
 )
 
+// longStringLen is the length above which strings are annotated with their
+// length when lengths are commented.
+const longStringLen = 64
+
 type addrType struct {
 	addr uintptr
 	typ  reflect.Type
 }
 
+// pointerDepths records the depths at which pointers were followed in
+// order to detect circular references. Pointers are always recorded at
+// a depth at least as deep as any other recorded pointer, so pointers
+// deeper than a given depth can be forgotten without a search.
+type pointerDepths struct {
+	depths map[uintptr]int
+	stack  []addrDepth
+}
+
+// addrDepth is a pointer and the depth at which it was followed.
+type addrDepth struct {
+	addr  uintptr
+	depth int
+}
+
+// add records that addr was followed at the given depth.
+func (p *pointerDepths) add(addr uintptr, depth int) {
+	if p.depths == nil {
+		p.depths = make(map[uintptr]int)
+	}
+	p.depths[addr] = depth
+	p.stack = append(p.stack, addrDepth{addr: addr, depth: depth})
+}
+
+// depth returns the depth at which addr was followed, if it was.
+func (p *pointerDepths) depth(addr uintptr) (int, bool) {
+	d, ok := p.depths[addr]
+	return d, ok
+}
+
+// forgetDeeper removes the pointers followed deeper than depth.
+func (p *pointerDepths) forgetDeeper(depth int) {
+	i := len(p.stack)
+	for i > 0 && p.stack[i-1].depth > depth {
+		i--
+		delete(p.depths, p.stack[i].addr)
+	}
+	p.stack = p.stack[:i]
+}
+
 // dumpState contains information about the state of a dump operation.
 type dumpState struct {
 	w                io.Writer
 	depth            int
-	pointers         map[uintptr]int
+	pointers         pointerDepths
 	nodes            map[addrType]struct{}
 	displayed        map[addrType]struct{}
 	ignoreNextType   bool
 	ignoreNextIndent bool
+	sortNextSlice    bool
+	inHidden         bool
+	ctx              context.Context
+	visited          int
+	limit            *limitWriter
+	held             []func()
+	errw             *errWriter
+	scratch          []byte
+	indents          []byte
+	types            map[reflect.Type][]byte
+	q                *qualifier
 	cs               *ConfigState
 }
 
@@ -73,7 +135,31 @@ func (d *dumpState) indent() {
 		d.ignoreNextIndent = false
 		return
 	}
-	d.w.Write(bytes.Repeat([]byte(d.cs.Indent), d.depth))
+	d.w.Write(d.indentBytes())
+}
+
+// indentBytes returns the indentation for the current depth. The returned
+// slice is shared between calls.
+func (d *dumpState) indentBytes() []byte {
+	n := len(d.cs.Indent) * d.depth
+	for len(d.indents) < n {
+		d.indents = append(d.indents, d.cs.Indent...)
+	}
+	return d.indents[:n]
+}
+
+// typeBytes returns the string representation of typ as written in dumps.
+// The returned slice is shared between calls and must not be modified.
+func (d *dumpState) typeBytes(typ reflect.Type) []byte {
+	if b, ok := d.types[typ]; ok {
+		return b
+	}
+	if d.types == nil {
+		d.types = make(map[reflect.Type][]byte)
+	}
+	b := bytes.ReplaceAll([]byte(d.q.typeString(typ)), interfaceTypeBytes, interfaceBytes)
+	d.types[typ] = b
+	return b
 }
 
 // unpackValue returns values inside of non-nil interfaces when possible.
@@ -93,15 +179,15 @@ func (d *dumpState) unpackValue(v reflect.Value) (val reflect.Value, wasPtr, sta
 func (d *dumpState) dumpPtr(v reflect.Value) {
 	// Remove pointers below the current depth from map used to detect
 	// circular refs.
-	for k, depth := range d.pointers {
-		if depth > d.depth {
-			delete(d.pointers, k)
-		}
-	}
+	d.pointers.forgetDeeper(d.depth)
 
 	// Keep list of all dereferenced pointers to show later.
 	var pointerChain []uintptr
 
+	// Keep the types of the dereferenced pointers in case
+	// they cannot be rendered with address-of operators.
+	var ptrTypes []reflect.Type
+
 	// Record the value's address.
 	value := addrType{addr: v.Pointer()}
 
@@ -123,12 +209,13 @@ func (d *dumpState) dumpPtr(v reflect.Value) {
 		if d.cs.CommentPointers {
 			pointerChain = append(pointerChain, addr)
 		}
-		if pd, ok := d.pointers[addr]; ok && pd < d.depth {
+		if pd, ok := d.pointers.depth(addr); ok && pd < d.depth {
 			cycleFound = true
 			indirects--
 			break
 		}
-		d.pointers[addr] = d.depth
+		d.pointers.add(addr, d.depth)
+		ptrTypes = append(ptrTypes, v.Type())
 
 		v = v.Elem()
 		if v.Kind() == reflect.Interface {
@@ -144,37 +231,60 @@ func (d *dumpState) dumpPtr(v reflect.Value) {
 	value.typ = v.Type()
 	_, displayed := d.displayed[value]
 
-	// Display type information.
+	// Display type information. Channels that are constructed provide
+	// their own type information.
+	construct := !displayed && !nilFound && !cycleFound && d.constructChan(v)
+
+	// Wrap the levels of indirection that cannot be written as the
+	// address of the dumped value.
+	var wrap int
+	if d.cs.PointerStyle != AddressOf && !displayed && !cycleFound {
+		wrap = indirects
+		if wrap != 0 && !nilFound && !construct && d.isCompositeLiteral(v) {
+			wrap--
+		}
+		d.beginPtrWrap(ptrTypes[:wrap])
+		indirects -= wrap
+	}
+
 	var typeBytes []byte
 	if displayed {
 		d.w.Write(openParenBytes)
-		typeBytes = []byte(typeString(orig.Type(), d.cs.LocalPackage))
+		typeBytes = d.typeBytes(orig.Type())
 	} else {
-		d.w.Write(bytes.Repeat(ampersandBytes, indirects))
-		typeBytes = []byte(typeString(v.Type(), d.cs.LocalPackage))
-	}
-	kind := v.Kind()
-	bufferedChan := kind == reflect.Chan && v.Cap() != 0
-	if kind == reflect.Ptr || bufferedChan {
-		d.w.Write(openParenBytes)
-	}
-	d.w.Write(bytes.ReplaceAll(typeBytes, interfaceTypeBytes, interfaceBytes))
-	if displayed {
-		d.w.Write(closeParenBytes)
+		if d.cs.Gofmt {
+			// Separate the operators so they are not
+			// parsed as a logical and.
+			for i := 0; i < indirects; i++ {
+				if i != 0 {
+					d.w.Write(spaceBytes)
+				}
+				d.w.Write(ampersandBytes)
+			}
+		} else {
+			for i := 0; i < indirects; i++ {
+				d.w.Write(ampersandBytes)
+			}
+		}
+		typeBytes = d.typeBytes(v.Type())
 	}
-	switch {
-	case bufferedChan:
-		switch len := v.Len(); len {
-		case 0:
-			fmt.Fprintf(d.w, ", %d", v.Cap())
-		case 1:
-			fmt.Fprintf(d.w, ", %d /* %d element */", v.Cap(), len)
-		default:
-			fmt.Fprintf(d.w, ", %d /* %d elements */", v.Cap(), len)
+	if !construct {
+		kind := v.Kind()
+		bufferedChan := kind == reflect.Chan && v.Cap() != 0
+		parenType := kind == reflect.Ptr || bufferedChan || !displayed && d.needsParens(kind)
+		if parenType {
+			d.w.Write(openParenBytes)
+		}
+		d.w.Write(typeBytes)
+		if displayed {
+			d.w.Write(closeParenBytes)
+		}
+		if bufferedChan {
+			d.writeChanCap(v)
+		}
+		if parenType {
+			d.w.Write(closeParenBytes)
 		}
-		fallthrough
-	case kind == reflect.Ptr:
-		d.w.Write(closeParenBytes)
 	}
 
 	// Display pointer information.
@@ -184,7 +294,7 @@ func (d *dumpState) dumpPtr(v reflect.Value) {
 			if i > 0 {
 				d.w.Write(pointerChainBytes)
 			}
-			printHexPtr(d.w, addr, true)
+			d.writeHexPtr(addr, true)
 		}
 		d.w.Write(closeCommentBytes)
 	}
@@ -197,7 +307,7 @@ func (d *dumpState) dumpPtr(v reflect.Value) {
 		d.w.Write(closeParenBytes)
 
 	case cycleFound, displayed:
-		d.w.Write(circularBytes)
+		d.writeCircular()
 
 	default:
 		d.ignoreNextType = true
@@ -209,17 +319,427 @@ func (d *dumpState) dumpPtr(v reflect.Value) {
 		d.displayed[value] = struct{}{}
 		d.dump(v, true, false, false, addr)
 	}
+
+	if wrap != 0 {
+		d.endPtrWrap(ptrTypes[:wrap])
+	}
+}
+
+// isCompositeLiteral returns whether v is dumped as a composite literal,
+// so its address may be taken.
+func (d *dumpState) isCompositeLiteral(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Struct, reflect.Array:
+		return true
+	case reflect.Map:
+		return !v.IsNil()
+	case reflect.Slice:
+		enc, _ := d.byteEncoding(v)
+		return !v.IsNil() && enc == HexDumpBytes
+	default:
+		return false
+	}
+}
+
+// beginPtrWrap writes the start of the rendering of the pointers with the
+// given types, outermost first, according to the configured pointer style.
+func (d *dumpState) beginPtrWrap(ptrs []reflect.Type) {
+	if len(ptrs) == 0 {
+		return
+	}
+	switch d.cs.PointerStyle {
+	case PtrHelper:
+		ptr := d.q.selector(utterPath, "utter", "Ptr")
+		for _, p := range ptrs {
+			d.w.Write([]byte(ptr))
+			if p.Elem().Kind() == reflect.Interface {
+				// The type argument cannot be inferred from
+				// the dynamic type of the value.
+				d.w.Write(openBracketBytes)
+				d.w.Write(d.typeBytes(p.Elem()))
+				d.w.Write(closeBracketBytes)
+			}
+			d.w.Write(openParenBytes)
+		}
+	case PtrClosure:
+		fmt.Fprintf(d.w, "func() %s {\n", d.typeBytes(ptrs[0]))
+		d.depth++
+		d.indent()
+		d.writePtrVar("v", ptrs[len(ptrs)-1].Elem())
+	}
+}
+
+// endPtrWrap writes the end of the rendering started by beginPtrWrap.
+func (d *dumpState) endPtrWrap(ptrs []reflect.Type) {
+	switch d.cs.PointerStyle {
+	case PtrHelper:
+		d.w.Write(bytes.Repeat(closeParenBytes, len(ptrs)))
+	case PtrClosure:
+		d.w.Write(newlineBytes)
+		prev := "v"
+		for i := 1; i < len(ptrs); i++ {
+			name := "p" + strconv.Itoa(i)
+			d.indent()
+			d.writePtrVar(name, ptrs[len(ptrs)-1-i].Elem())
+			fmt.Fprintf(d.w, "&%s\n", prev)
+			prev = name
+		}
+		d.indent()
+		fmt.Fprintf(d.w, "return &%s\n", prev)
+		d.depth--
+		d.indent()
+		d.w.Write(closeBraceBytes)
+		d.w.Write(callBytes)
+	}
+}
+
+// writePtrVar writes the start of the declaration of the named variable
+// of type typ in a function literal rendering of pointers.
+func (d *dumpState) writePtrVar(name string, typ reflect.Type) {
+	if typ.Kind() == reflect.Interface {
+		// The variable must have the interface type
+		// rather than the type of the value.
+		fmt.Fprintf(d.w, "var %s %s = ", name, d.typeBytes(typ))
+		return
+	}
+	fmt.Fprintf(d.w, "%s := ", name)
+}
+
+// writeChanCap writes the capacity and the number of queued elements of the
+// buffered channel v.
+func (d *dumpState) writeChanCap(v reflect.Value) {
+	if d.cs.Gofmt {
+		// A type conversion cannot hold the capacity,
+		// so it is only noted in a comment.
+		switch n := v.Len(); n {
+		case 0:
+			fmt.Fprintf(d.w, " /* cap=%d */", v.Cap())
+		case 1:
+			fmt.Fprintf(d.w, " /* cap=%d, %d element */", v.Cap(), n)
+		default:
+			fmt.Fprintf(d.w, " /* cap=%d, %d elements */", v.Cap(), n)
+		}
+		return
+	}
+	fmt.Fprintf(d.w, ", %d", v.Cap())
+	switch n := v.Len(); n {
+	case 0:
+	case 1:
+		fmt.Fprintf(d.w, " /* %d element */", n)
+	default:
+		fmt.Fprintf(d.w, " /* %d elements */", n)
+	}
+}
+
+// needsParens returns whether types of the given kind must be parenthesized
+// when used in a conversion. Func and channel types are parenthesized when
+// the Gofmt option is set to avoid ambiguous conversions.
+func (d *dumpState) needsParens(kind reflect.Kind) bool {
+	return d.cs.Gofmt && (kind == reflect.Func || kind == reflect.Chan)
+}
+
+// writeCircular writes the marker for a value that has already been shown.
+func (d *dumpState) writeCircular() {
+	if d.cs.Gofmt {
+		d.w.Write(circularNilBytes)
+		return
+	}
+	d.w.Write(circularBytes)
+}
+
+// constructChan returns whether v is a buffered channel that should be
+// rendered as a constructing function call.
+func (d *dumpState) constructChan(v reflect.Value) bool {
+	if d.cs.ChanContents != ConstructChanContents || v.Kind() != reflect.Chan || v.IsNil() || v.Cap() == 0 {
+		return false
+	}
+	if d.chanShown(v) {
+		return false
+	}
+	_, ok := chanElems(v)
+	return ok
+}
+
+// chanShown returns whether v is a buffered channel whose queued elements
+// are rendered and which is already being rendered at a shallower depth.
+// Rendering its elements again would not terminate.
+func (d *dumpState) chanShown(v reflect.Value) bool {
+	if d.cs.ChanContents == OmitChanContents || v.Kind() != reflect.Chan || v.IsNil() || v.Cap() == 0 {
+		return false
+	}
+	// Remove pointers below the current depth from map used to detect
+	// circular refs.
+	d.pointers.forgetDeeper(d.depth)
+	pd, ok := d.pointers.depth(v.Pointer())
+	return ok && pd < d.depth
+}
+
+// dumpChanConstructor writes the buffered channel v as a call to a function
+// literal that makes the channel and sends its queued elements.
+func (d *dumpState) dumpChanConstructor(v reflect.Value) {
+	elems, _ := chanElems(v)
+	d.pointers.add(v.Pointer(), d.depth)
+	typ := v.Type()
+	fmt.Fprintf(d.w, "func() %s {\n", d.q.typeString(typ))
+	d.depth++
+	d.indent()
+	fmt.Fprintf(d.w, "c := make(%s, %d)\n", d.q.typeString(reflect.ChanOf(reflect.BothDir, typ.Elem())), v.Cap())
+	for _, e := range elems {
+		d.indent()
+		d.w.Write(chanSendBytes)
+		d.ignoreNextIndent = true
+		val, wasPtr, static, _, addr := d.unpackValue(e)
+		d.dump(val, wasPtr, static, false, addr)
+		d.w.Write(newlineBytes)
+	}
+	d.indent()
+	d.w.Write(returnChanBytes)
+	d.depth--
+	d.indent()
+	d.w.Write(closeBraceBytes)
+	d.w.Write(callBytes)
+}
+
+// writeChanComment writes the elements queued in the buffered channel v as a
+// comment. The comment is written on a single line since a block comment
+// holding a newline ends the line it is in, which is not valid within the
+// parentheses of a conversion.
+func (d *dumpState) writeChanComment(v reflect.Value) {
+	elems, ok := chanElems(v)
+	if !ok || len(elems) == 0 {
+		return
+	}
+	d.pointers.add(v.Pointer(), d.depth)
+
+	var buf heldBuffer
+	restore := d.hold(&buf, func() {
+		// Block comments do not nest, so break any comment
+		// terminators in the rendered elements.
+		d.w.Write(openCommentBytes)
+		d.w.Write(spaceBytes)
+		d.scratch = appendSingleLine(d.scratch[:0], bytes.ReplaceAll(buf.Bytes(), []byte("*/"), []byte("* /")))
+		d.w.Write(d.scratch)
+		d.w.Write(spaceBytes)
+		d.w.Write(closeCommentBytes[:len(closeCommentBytes)-1])
+	})
+	d.w.Write(openBraceNewlineBytes)
+	d.depth++
+	for _, e := range elems {
+		val, wasPtr, static, _, addr := d.unpackValue(e)
+		d.dump(val, wasPtr, static, true, addr)
+		d.w.Write(commaNewlineBytes)
+	}
+	d.depth--
+	d.indent()
+	d.w.Write(closeBraceBytes)
+	restore()
+}
+
+// writeLenComment writes a comment holding the length of a value, and its
+// capacity if cap is not negative.
+func (d *dumpState) writeLenComment(len, cap int) {
+	if cap < 0 {
+		fmt.Fprintf(d.w, " /* len=%d */", len)
+		return
+	}
+	fmt.Fprintf(d.w, " /* len=%d cap=%d */", len, cap)
+}
+
+// dumpField writes the struct field f with the value v on its own line.
+func (d *dumpState) dumpField(f reflect.StructField, v reflect.Value, align *aligner) {
+	unpacked, wasPtr, static, _, addr := d.unpackValue(v)
+	if d.cs.OmitZero && isZero(unpacked) {
+		return
+	}
+	d.indent()
+	align.startEntry()
+	d.writeString(f.Name)
+	d.w.Write(colonBytes)
+	align.endKey()
+	d.w.Write(spaceBytes)
+	d.ignoreNextIndent = true
+	d.sortNextSlice = hasTagOption(f.Tag, "sort")
+	d.dump(unpacked, wasPtr, static, false, addr)
+	d.sortNextSlice = false
+	if d.cs.CommentLayout {
+		d.w.Write(commaBytes)
+		fmt.Fprintf(d.w, " /* offset=%d size=%d */\n", f.Offset, f.Type.Size())
+		align.endEntry()
+		return
+	}
+	d.w.Write(commaNewlineBytes)
+	align.endEntry()
+}
+
+// unexportedFields returns the rendering strategy for foreign unexported
+// fields. Omitting fields silently changes the value that is rendered, so
+// when no target package is known the fields are commented instead.
+func (d *dumpState) unexportedFields() UnexportedFields {
+	if d.cs.UnexportedFields == OmitForeignUnexported && d.q.target == "" && d.q.local == "" {
+		return CommentForeignUnexported
+	}
+	return d.cs.UnexportedFields
+}
+
+// foreignUnexported returns whether f is an unexported struct field declared
+// in a package other than the package the dump is written for.
+func (d *dumpState) foreignUnexported(f reflect.StructField) bool {
+	return f.PkgPath != "" && !d.q.isLocal(f.PkgPath, importName(f.PkgPath))
+}
+
+// writeCommented writes the output of f with each line commented out by a
+// line comment marker following the current indentation.
+func (d *dumpState) writeCommented(f func()) {
+	indent := d.indentBytes()
+	var buf heldBuffer
+	restore := d.hold(&buf, func() {
+		for _, line := range bytes.SplitAfter(buf.Bytes(), newlineBytes) {
+			if len(line) == 0 {
+				continue
+			}
+			d.w.Write(indent)
+			d.w.Write(lineCommentBytes)
+			d.w.Write(bytes.TrimPrefix(line, indent))
+		}
+	})
+	f()
+	restore()
+}
+
+// hold redirects the output of the dump to buf until the returned function
+// is called. The function restores the output and calls flush to write the
+// held output. The bytes held in buf are counted against the MaxBytes budget
+// and if the dump is aborted, flush is called by flushHeld to write the
+// partial output.
+func (d *dumpState) hold(buf *heldBuffer, flush func()) (restore func()) {
+	w := d.w
+	buf.limit = d.limit
+	d.w = buf
+	restore = func() {
+		d.held = d.held[:len(d.held)-1]
+		d.w = w
+		d.limit.release(buf.Len())
+		flush()
+	}
+	d.held = append(d.held, restore)
+	return restore
+}
+
+// flushHeld writes the output held by redirections of an aborted dump.
+func (d *dumpState) flushHeld() {
+	for len(d.held) != 0 {
+		d.held[len(d.held)-1]()
+	}
 }
 
 // dumpSlice handles formatting of arrays and slices.  Byte (uint8 under
-// reflection) arrays and slices are dumped in hexdump -C fashion.
-func (d *dumpState) dumpSlice(v reflect.Value, canElideCompound bool) {
+// reflection) arrays and slices are dumped in hexdump -C fashion unless
+// enc, the encoding of v returned by byteEncoding with its bytes in buf,
+// specifies otherwise.
+func (d *dumpState) dumpSlice(v reflect.Value, canElideCompound, sorted bool, enc ByteEncoding, buf []uint8) {
+	if enc != HexDumpBytes {
+		// Encoded bytes are not sorted since their
+		// order is part of the encoded content.
+		d.writeEncodedBytes(enc, buf)
+		if d.cs.CommentLengths {
+			d.writeLenComment(v.Len(), v.Cap())
+		}
+		return
+	}
+
+	format := d.sliceFormat(v)
+	nPeriod := format.nPeriod
+	numEntries := v.Len()
+
+	// Prepare indenting for slice.
+	d.w.Write(openBraceBytes)
+	if d.cs.CommentLengths && v.Kind() == reflect.Slice {
+		d.writeLenComment(numEntries, v.Cap())
+		if nPeriod == 0 {
+			d.w.Write(spaceBytes)
+		}
+	}
+	if nPeriod != 0 {
+		d.w.Write(newlineBytes)
+	}
+	d.depth++
+	defer func() {
+		d.depth--
+		if nPeriod != 0 {
+			d.indent()
+		}
+		d.w.Write(closeBraceBytes)
+	}()
+
+	elems := v
+	if sorted && !format.runes {
+		// Sort the elements that are dumped without recursion
+		// by value and sort the remainder by index to retain
+		// their addresses.
+		order := sortedIndexes(v)
+		switch {
+		case format.hexBytes:
+			buf := make([]uint8, len(order))
+			for i, j := range order {
+				buf[i] = format.buf[j]
+			}
+			format.buf = buf
+		case format.hexWords:
+			elems = permute(v, order)
+		default:
+			format.order = order
+		}
+	}
+	d.dumpElements(elems, format, canElideCompound)
+
+	// Show the elements between the length and capacity as comments.
+	if d.cs.CommentSpare && nPeriod != 0 && v.Kind() == reflect.Slice && v.Cap() > numEntries {
+		spare := v.Slice(numEntries, v.Cap())
+		format := d.sliceFormat(spare)
+		format.nPeriod = nPeriod
+		d.writeCommented(func() {
+			d.dumpElements(spare, format, canElideCompound)
+		})
+	}
+}
+
+// elementFormat describes how the elements of an array or slice are written.
+type elementFormat struct {
+	// buf holds the bytes to dump when hexBytes is true.
+	buf      []uint8
+	hexBytes bool
+
+	// hexWords indicates that the integer elements
+	// should be dumped as hexadecimal words.
+	hexWords bool
+
+	// runes indicates that each line of int32 elements
+	// is annotated with rune information.
+	runes bool
+
+	// order holds the indexes of the elements in the
+	// order they are written. If order is nil, the
+	// elements are written in index order.
+	order []int
+
+	// nPeriod is the number of elements to write on
+	// each line.
+	nPeriod int
+}
+
+// sliceFormat returns how the elements of the array or slice v should be
+// formatted.
+func (d *dumpState) sliceFormat(v reflect.Value) elementFormat {
 	// Determine whether this type should be hex dumped or not.  Also,
 	// for types which should be hexdumped, try to use the underlying data
 	// first, then fall back to trying to convert them to a uint8 slice.
-	var buf []uint8
-	doConvert := false
-	doHexDump := false
+	var (
+		buf       []uint8
+		doConvert bool
+		doHexDump bool
+		hexWords  bool
+		runes     bool
+	)
 	nPeriod := 1
 	numEntries := v.Len()
 	vt := v.Type().Elem()
@@ -237,22 +757,7 @@ func (d *dumpState) dumpSlice(v reflect.Value, canElideCompound bool) {
 		// Try to use existing uint8 slices and fall back to converting
 		// and copying if that fails.
 		case kind == reflect.Uint8:
-			// We need an addressable interface to convert the type back
-			// into a byte slice.  However, the reflect package won't give
-			// us an interface on certain things like unexported struct
-			// fields in order to enforce visibility rules.  We use unsafe
-			// to bypass these restrictions since this package does not
-			// mutate the values.
-			vs := v
-			if !vs.CanInterface() || !vs.CanAddr() {
-				vs = unsafeReflectValue(vs)
-			}
-			vs = vs.Slice(0, numEntries)
-
-			// Use the existing uint8 slice if it can be type
-			// asserted.
-			iface := vs.Interface()
-			if slice, ok := iface.([]uint8); ok {
+			if slice, ok := byteSlice(v); ok {
 				buf = slice
 				doHexDump = true
 				break
@@ -262,8 +767,16 @@ func (d *dumpState) dumpSlice(v reflect.Value, canElideCompound bool) {
 			// be type asserted to a uint8 slice.
 			doConvert = true
 
+		// Named integer types may have registered symbols or
+		// radixes, and rune comments take precedence, so only
+		// other predeclared integer types are dumped as words.
+		case d.cs.HexWords && isInteger(kind) && vt.PkgPath() == "" &&
+			!(d.cs.CommentRunes && kind == reflect.Int32):
+			hexWords = true
+
 		case isNumeric(kind):
 			nPeriod = d.cs.NumericWidth
+			runes = d.cs.CommentRunes && kind == reflect.Int32
 
 		case kind == reflect.String:
 			nPeriod = d.cs.StringWidth
@@ -281,32 +794,115 @@ func (d *dumpState) dumpSlice(v reflect.Value, canElideCompound bool) {
 			doHexDump = true
 		}
 	}
+	return elementFormat{buf: buf, hexBytes: doHexDump, hexWords: hexWords, runes: runes, nPeriod: nPeriod}
+}
 
-	// Prepare indenting for slice.
-	if nPeriod == 0 {
-		d.w.Write(openBraceBytes)
-	} else {
-		d.w.Write(openBraceNewlineBytes)
+// byteSlice returns the underlying data of the array or slice of uint8 v if
+// it can be type asserted to a []uint8.
+func byteSlice(v reflect.Value) ([]uint8, bool) {
+	// We need an addressable interface to convert the type back
+	// into a byte slice.  However, the reflect package won't give
+	// us an interface on certain things like unexported struct
+	// fields in order to enforce visibility rules.  We use unsafe
+	// to bypass these restrictions since this package does not
+	// mutate the values.
+	vs := v
+	if !vs.CanInterface() || !vs.CanAddr() {
+		vs = unsafeReflectValue(vs)
 	}
-	d.depth++
-	defer func() {
-		d.depth--
-		if nPeriod != 0 {
-			d.indent()
+	vs = vs.Slice(0, v.Len())
+
+	// Use the existing uint8 slice if it can be type
+	// asserted.
+	slice, ok := vs.Interface().([]uint8)
+	return slice, ok
+}
+
+// byteEncoding returns the encoding to use for v and the bytes of v.
+// The bytes are only returned for non-empty byte slices that are not
+// rendered as a hexdump.
+func (d *dumpState) byteEncoding(v reflect.Value) (ByteEncoding, []uint8) {
+	if d.cs.ByteEncoding == HexDumpBytes || v.Kind() != reflect.Slice || v.Type().Elem() != uint8Type || v.IsNil() || v.Len() == 0 {
+		return HexDumpBytes, nil
+	}
+	buf, ok := byteSlice(v)
+	if !ok {
+		buf = make([]uint8, v.Len())
+		for i := range buf {
+			buf[i] = uint8(v.Index(i).Uint())
 		}
-		d.w.Write(closeBraceBytes)
-	}()
+	}
+
+	enc := d.cs.ByteEncoding
+	switch enc {
+	case StringBytes:
+		if !isMostlyText(buf) {
+			return HexDumpBytes, nil
+		}
+	case Base64Bytes:
+	case GzipBytes:
+		threshold := d.cs.GzipThreshold
+		if threshold <= 0 {
+			threshold = 1024
+		}
+		if len(buf) < threshold {
+			enc = Base64Bytes
+		}
+	default:
+		return HexDumpBytes, nil
+	}
+	return enc, buf
+}
+
+// writeEncodedBytes writes buf as a parenthesized expression using the
+// encoding enc.
+func (d *dumpState) writeEncodedBytes(enc ByteEncoding, buf []uint8) {
+	d.w.Write(openParenBytes)
+	switch enc {
+	case StringBytes:
+		d.writeQuoted(string(buf))
+	case Base64Bytes:
+		d.writeHelperCall("MustBase64", base64.StdEncoding.EncodeToString(buf))
+	case GzipBytes:
+		d.writeHelperCall("MustGzipBase64", gzipBase64(buf))
+	}
+	d.w.Write(closeParenBytes)
+}
 
+// writeHelperCall writes a call to the named utter package function
+// with the string argument arg.
+func (d *dumpState) writeHelperCall(name, arg string) {
+	d.w.Write([]byte(d.q.selector(utterPath, "utter", name)))
+	d.w.Write(openParenBytes)
+	d.w.Write([]byte(strconv.Quote(arg)))
+	d.w.Write(closeParenBytes)
+}
+
+// dumpElements writes the elements of the array or slice v using the
+// formatting returned by sliceFormat.
+func (d *dumpState) dumpElements(v reflect.Value, format elementFormat, canElideCompound bool) {
 	// Hexdump the entire slice as needed.
-	if doHexDump {
-		indent := strings.Repeat(d.cs.Indent, d.depth)
-		hexDump(d.w, buf, indent, d.cs.BytesWidth, d.cs.CommentBytes, d.cs.AddressBytes)
+	if format.hexBytes {
+		d.scratch = hexDump(d.w, d.scratch, format.buf, d.indentBytes(), d.cs.BytesWidth, d.cs.CommentBytes, d.cs.AddressBytes, d.visit)
+		return
+	}
+	if format.hexWords {
+		order := d.cs.ByteOrder
+		if order == nil {
+			order = nativeOrder
+		}
+		d.scratch = hexDumpWords(d.w, d.scratch, v, d.indentBytes(), d.cs.BytesWidth, d.cs.CommentBytes, d.cs.AddressBytes, order, d.visit)
 		return
 	}
+	nPeriod := format.nPeriod
 
 	// Recursively call dump for each item.
+	numEntries := v.Len()
 	for i := 0; i < numEntries; i++ {
 		vi := v.Index(i)
+		if format.order != nil {
+			vi = v.Index(format.order[i])
+		}
 		if nPeriod == 0 || i%nPeriod != 0 {
 			d.ignoreNextIndent = true
 		}
@@ -319,10 +915,52 @@ func (d *dumpState) dumpSlice(v reflect.Value, canElideCompound bool) {
 			}
 			break
 		}
+		if format.runes {
+			d.w.Write(commaBytes)
+			d.writeRuneComment(v, i-i%nPeriod, i+1)
+			d.w.Write(newlineBytes)
+			continue
+		}
 		d.w.Write(commaNewlineBytes)
 	}
 }
 
+// writeRuneComment writes a line comment annotating the int32 elements
+// of v from index i to j with their rune information.
+func (d *dumpState) writeRuneComment(v reflect.Value, i, j int) {
+	d.w.Write(spaceBytes)
+	d.w.Write(lineCommentBytes)
+	for k := i; k < j; k++ {
+		if k > i {
+			d.w.Write(commaSpaceBytes)
+		}
+		d.w.Write([]byte(runeAnnotation(rune(v.Index(k).Int()))))
+	}
+}
+
+// writeStringRunes writes a comment annotating the runes of s with their
+// rune information. Bytes that are not valid UTF-8 are flagged with their
+// offset.
+func (d *dumpState) writeStringRunes(s string) {
+	if s == "" {
+		return
+	}
+	d.w.Write([]byte(" /* "))
+	for i := 0; i < len(s); {
+		if i > 0 {
+			d.w.Write(commaSpaceBytes)
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			fmt.Fprintf(d.w, "invalid %#02x at %d", s[i], i)
+		} else {
+			d.w.Write([]byte(runeAnnotation(r)))
+		}
+		i += size
+	}
+	d.w.Write([]byte(" */"))
+}
+
 // isNumeric returns true for all numeric and boolean kinds.
 func isNumeric(k reflect.Kind) bool {
 	switch k {
@@ -345,9 +983,15 @@ func isNumeric(k reflect.Kind) bool {
 // appropriately.  It is a recursive function, however circular data structures
 // are detected and annotated.
 func (d *dumpState) dump(v reflect.Value, wasPtr, static, canElideCompound bool, addr uintptr) {
+	d.visit()
+
 	// Handle invalid reflect values immediately.
 	kind := v.Kind()
 	if kind == reflect.Invalid {
+		if d.cs.Gofmt {
+			d.w.Write(invalidNilBytes)
+			return
+		}
 		d.w.Write(invalidAngleBytes)
 		return
 	}
@@ -359,6 +1003,13 @@ func (d *dumpState) dump(v reflect.Value, wasPtr, static, canElideCompound bool,
 		return
 	}
 
+	// Slices may be sorted by configuration or by a field tag.
+	sortSlice := kind == reflect.Slice && (d.cs.SortSlices || d.sortNextSlice)
+	d.sortNextSlice = false
+
+	// Byte slices may be rendered as an encoded conversion.
+	enc, encoded := d.byteEncoding(v)
+
 	typ := v.Type()
 	wantType := true
 	interfaceContext := kind == reflect.Interface
@@ -368,6 +1019,39 @@ func (d *dumpState) dump(v reflect.Value, wasPtr, static, canElideCompound bool,
 		if !canElideCompound {
 			wantType = wantType || isCompound(kind)
 		}
+		if enc != HexDumpBytes {
+			// Encoded byte slices are conversions, so
+			// they always need their type.
+			wantType = true
+		}
+	}
+
+	// Buffered channels may hold themselves, so they are only
+	// rendered with their elements once.
+	shownChan := d.chanShown(v)
+
+	// Buffered channels may be rendered by construction.
+	if d.constructChan(v) {
+		if !d.ignoreNextType {
+			d.indent()
+		}
+		d.ignoreNextType = false
+		d.dumpChanConstructor(v)
+		return
+	}
+
+	// Named integer values may be printed as constant names.
+	if sym, ok := d.symbol(v); ok {
+		if d.ignoreNextType {
+			d.ignoreNextType = false
+			d.w.Write(openParenBytes)
+			d.w.Write([]byte(sym))
+			d.w.Write(closeParenBytes)
+			return
+		}
+		d.indent()
+		d.w.Write([]byte(sym))
+		return
 	}
 
 	// Print type information unless already handled elsewhere.
@@ -375,36 +1059,31 @@ func (d *dumpState) dump(v reflect.Value, wasPtr, static, canElideCompound bool,
 		d.indent()
 		if wantType {
 			bufferedChan := v.Kind() == reflect.Chan && v.Cap() != 0
-			if bufferedChan {
+			parenType := bufferedChan || d.needsParens(kind)
+			if parenType {
 				d.w.Write(openParenBytes)
 			}
-			typeBytes := []byte(typeString(v.Type(), d.cs.LocalPackage))
-			d.w.Write(bytes.ReplaceAll(typeBytes, interfaceTypeBytes, interfaceBytes))
+			d.w.Write(d.typeBytes(v.Type()))
 			if bufferedChan {
-				switch len := v.Len(); len {
-				case 0:
-					fmt.Fprintf(d.w, ", %d)", v.Cap())
-				case 1:
-					fmt.Fprintf(d.w, ", %d /* %d element */)", v.Cap(), len)
-				default:
-					fmt.Fprintf(d.w, ", %d /* %d elements */)", v.Cap(), len)
-				}
+				d.writeChanCap(v)
+			}
+			if parenType {
+				d.w.Write(closeParenBytes)
 			}
 		}
 	}
 	d.ignoreNextType = false
 
-	if wantType {
-		switch kind {
-		case reflect.Invalid, reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
-		default:
-			d.w.Write(openParenBytes)
-		}
+	// Values other than composite literals and already shown
+	// channels are written as a conversion.
+	convert := wantType && !isCompound(kind) && !shownChan
+	if convert {
+		d.w.Write(openParenBytes)
 	}
 
 	if _, referenced := d.nodes[addrType{addr, typ}]; !wasPtr && referenced {
 		d.w.Write(openCommentBytes)
-		printHexPtr(d.w, addr, true)
+		d.writeHexPtr(addr, true)
 		d.w.Write(closeCommentBytes)
 	}
 	switch kind {
@@ -416,23 +1095,30 @@ func (d *dumpState) dump(v reflect.Value, wasPtr, static, canElideCompound bool,
 		printBool(d.w, v.Bool())
 
 	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
-		printInt(d.w, v.Int(), 10)
+		d.printInteger(d.w, v.Type(), intBits(v))
 
 	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
-		d.w.Write(hexZeroBytes)
-		printUint(d.w, v.Uint(), 16)
-
-	case reflect.Float32:
-		printFloat(d.w, v.Float(), 32, !wantType)
-
-	case reflect.Float64:
-		printFloat(d.w, v.Float(), 64, !wantType)
-
-	case reflect.Complex64:
-		printComplex(d.w, v.Complex(), 32)
-
-	case reflect.Complex128:
-		printComplex(d.w, v.Complex(), 64)
+		d.printInteger(d.w, v.Type(), intBits(v))
+
+	case reflect.Float32, reflect.Float64:
+		// Special values written as calls must be converted
+		// to named types when the type is elided.
+		var named []byte
+		if !wantType && typ.PkgPath() != "" {
+			named = d.typeBytes(typ)
+		}
+		d.scratch = appendFloat(d.scratch[:0], v.Float(), typ.Bits(), named, !wantType, d.cs.FloatSpecials, d.cs.HexFloats)
+		d.w.Write(d.scratch)
+
+	case reflect.Complex64, reflect.Complex128:
+		// Calls to complex must be converted to named
+		// types when the type is elided.
+		var named []byte
+		if !wantType && typ.PkgPath() != "" {
+			named = d.typeBytes(typ)
+		}
+		d.scratch = appendComplex(d.scratch[:0], v.Complex(), typ.Bits()/2, named, d.cs.ComplexCalls, d.cs.HexFloats)
+		d.w.Write(d.scratch)
 
 	case reflect.Slice:
 		if v.IsNil() {
@@ -442,30 +1128,32 @@ func (d *dumpState) dump(v reflect.Value, wasPtr, static, canElideCompound bool,
 			break
 		}
 		if v.Len() == 0 {
-			d.dumpSlice(v, !interfaceContext)
+			d.dumpSlice(v, !interfaceContext, false, enc, encoded)
 			break
 		}
 		// Remove pointers below the current depth from map used to detect
 		// circular refs.
-		for k, depth := range d.pointers {
-			if depth > d.depth {
-				delete(d.pointers, k)
-			}
-		}
+		d.pointers.forgetDeeper(d.depth)
 		addr = v.Index(0).Addr().Pointer()
-		if pd, ok := d.pointers[addr]; ok && pd < d.depth {
-			d.w.Write(circularBytes)
+		if pd, ok := d.pointers.depth(addr); ok && pd < d.depth {
+			d.writeCircular()
 			break
 		}
-		d.pointers[addr] = d.depth
+		d.pointers.add(addr, d.depth)
 
 		fallthrough
 
 	case reflect.Array:
-		d.dumpSlice(v, !interfaceContext)
+		d.dumpSlice(v, !interfaceContext, sortSlice, enc, encoded)
 
 	case reflect.String:
 		d.writeQuoted(v.String())
+		if d.cs.CommentLengths && v.Len() > longStringLen {
+			d.writeLenComment(v.Len(), -1)
+		}
+		if d.cs.CommentRunes {
+			d.writeStringRunes(v.String())
+		}
 
 	case reflect.Interface:
 		// The only time we should get here is for nil interfaces due to
@@ -489,20 +1177,24 @@ func (d *dumpState) dump(v reflect.Value, wasPtr, static, canElideCompound bool,
 
 		// Remove pointers below the current depth from map used to detect
 		// circular refs.
-		for k, depth := range d.pointers {
-			if depth > d.depth {
-				delete(d.pointers, k)
-			}
-		}
+		d.pointers.forgetDeeper(d.depth)
 		addr := v.Pointer()
-		if pd, ok := d.pointers[addr]; ok && pd < d.depth {
-			d.w.Write(circularBytes)
+		if pd, ok := d.pointers.depth(addr); ok && pd < d.depth {
+			d.writeCircular()
 			break
 		}
-		d.pointers[addr] = d.depth
+		d.pointers.add(addr, d.depth)
 
-		d.w.Write(openBraceNewlineBytes)
+		d.w.Write(openBraceBytes)
+		if d.cs.CommentLengths {
+			d.writeLenComment(v.Len(), -1)
+		}
+		d.w.Write(newlineBytes)
 		d.depth++
+		var align *aligner
+		if d.cs.AlignFields {
+			align = d.beginAlign()
+		}
 		if d.cs.SortKeys {
 			iter := v.MapRange()
 			keys := make([]reflect.Value, 0, v.Len())
@@ -511,62 +1203,98 @@ func (d *dumpState) dump(v reflect.Value, wasPtr, static, canElideCompound bool,
 				keys = append(keys, iter.Key())
 				vals = append(vals, iter.Value())
 			}
-			sortMapByKeyVals(keys, vals)
+			sortMapByKeyVals(keys, vals, d.cs.KeyLess)
 			for i, key := range keys {
-				val, wasPtr, static, _, addr := d.unpackValue(key)
-				d.dump(val, wasPtr, static, !interfaceContext, addr)
-				d.w.Write(colonSpaceBytes)
-				d.ignoreNextIndent = true
-				val, wasPtr, static, _, addr = d.unpackValue(vals[i])
-				d.dump(val, wasPtr, static, !interfaceContext, addr)
-				d.w.Write(commaNewlineBytes)
+				d.dumpMapEntry(key, vals[i], !interfaceContext, align)
 			}
 		} else {
 			iter := v.MapRange()
 			for iter.Next() {
-				val, wasPtr, static, _, addr := d.unpackValue(iter.Key())
-				d.dump(val, wasPtr, static, !interfaceContext, addr)
-				d.w.Write(colonSpaceBytes)
-				d.ignoreNextIndent = true
-				val, wasPtr, static, _, addr = d.unpackValue(iter.Value())
-				d.dump(val, wasPtr, static, !interfaceContext, addr)
-				d.w.Write(commaNewlineBytes)
+				d.dumpMapEntry(iter.Key(), iter.Value(), !interfaceContext, align)
 			}
 		}
+		if align != nil {
+			d.endAlign(align)
+		}
 		d.depth--
 		d.indent()
 		d.w.Write(closeBraceBytes)
 
 	case reflect.Struct:
-		d.w.Write(openBraceNewlineBytes)
-		d.depth++
 		vt := v.Type()
+		d.w.Write(openBraceBytes)
+		if d.cs.CommentLayout {
+			fmt.Fprintf(d.w, " /* size=%d align=%d */", vt.Size(), vt.Align())
+		}
+		d.w.Write(newlineBytes)
+		d.depth++
+		var align *aligner
+		if d.cs.AlignFields {
+			align = d.beginAlign()
+		}
+		var end uintptr
+		var hidden []int
 		numFields := v.NumField()
 		for i := 0; i < numFields; i++ {
 			vtf := vt.Field(i)
+			if d.cs.CommentLayout {
+				d.writePadding(end, vtf.Offset)
+				end = vtf.Offset + vtf.Type.Size()
+			}
 			if d.cs.IgnoreUnexported && vtf.PkgPath != "" {
 				continue
 			}
-			unpacked, wasPtr, static, _, addr := d.unpackValue(v.Field(i))
-			if d.cs.OmitZero && isZero(unpacked) {
+			if unexported := d.unexportedFields(); unexported != ShowUnexported && !d.inHidden && d.foreignUnexported(vtf) {
+				if unexported == CommentForeignUnexported {
+					hidden = append(hidden, i)
+				}
 				continue
 			}
-			d.indent()
-			d.w.Write([]byte(vtf.Name))
-			d.w.Write(colonSpaceBytes)
-			d.ignoreNextIndent = true
-			d.dump(unpacked, wasPtr, static, false, addr)
-			d.w.Write(commaNewlineBytes)
+			d.dumpField(vtf, v.Field(i), align)
+		}
+		if d.cs.CommentLayout {
+			d.writePadding(end, vt.Size())
+		}
+		if align != nil {
+			d.endAlign(align)
+		}
+		if len(hidden) != 0 {
+			// Fields within the hidden fields are already
+			// in the comment, so they are shown in full.
+			d.inHidden = true
+			d.writeCommented(func() {
+				for _, i := range hidden {
+					d.dumpField(vt.Field(i), v.Field(i), nil)
+				}
+			})
+			d.inHidden = false
 		}
 		d.depth--
 		d.indent()
 		d.w.Write(closeBraceBytes)
 
 	case reflect.Uintptr:
-		printHexPtr(d.w, uintptr(v.Uint()), false)
+		d.writeHexPtr(uintptr(v.Uint()), false)
 
-	case reflect.UnsafePointer, reflect.Chan, reflect.Func:
-		printHexPtr(d.w, v.Pointer(), true)
+	case reflect.Chan:
+		if shownChan {
+			d.writeCircular()
+			break
+		}
+		d.writeHexPtr(v.Pointer(), true)
+		if d.cs.ChanContents == CommentChanContents && !v.IsNil() && v.Cap() != 0 {
+			d.writeChanComment(v)
+		}
+
+	case reflect.Func:
+		if d.cs.FuncNames && !v.IsNil() {
+			d.w.Write([]byte(funcName(v.Pointer(), d.q)))
+			break
+		}
+		d.writeHexPtr(v.Pointer(), true)
+
+	case reflect.UnsafePointer:
+		d.writeHexPtr(v.Pointer(), true)
 
 	// There were not any other types at the time this code was written, but
 	// fall back to letting the default fmt package handle it in case any new
@@ -578,33 +1306,207 @@ func (d *dumpState) dump(v reflect.Value, wasPtr, static, canElideCompound bool,
 			fmt.Fprintf(d.w, "%v", v.String())
 		}
 	}
-	if wantType {
-		switch kind {
-		case reflect.Invalid, reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
-		default:
-			d.w.Write(closeParenBytes)
+	if convert {
+		d.w.Write(closeParenBytes)
+	}
+}
+
+// dumpMapEntry writes the key/value entry of a map. If align is not nil, the
+// entry is recorded for alignment.
+func (d *dumpState) dumpMapEntry(key, value reflect.Value, canElideCompound bool, align *aligner) {
+	if align != nil {
+		d.indent()
+		d.ignoreNextIndent = true
+	}
+	align.startEntry()
+	val, wasPtr, static, _, addr := d.unpackValue(key)
+	d.dump(val, wasPtr, static, canElideCompound, addr)
+	d.w.Write(colonBytes)
+	align.endKey()
+	d.w.Write(spaceBytes)
+	d.ignoreNextIndent = true
+	val, wasPtr, static, _, addr = d.unpackValue(value)
+	d.dump(val, wasPtr, static, canElideCompound, addr)
+	d.w.Write(commaNewlineBytes)
+	align.endEntry()
+}
+
+// beginAlign redirects the output of the dump to a new aligner that
+// records the key/value entries of a struct or map.
+func (d *dumpState) beginAlign() *aligner {
+	a := &aligner{w: d.w}
+	a.restore = d.hold(&a.buf, a.flush)
+	return a
+}
+
+// endAlign writes the aligned entries recorded by a to the output that
+// was redirected by beginAlign.
+func (d *dumpState) endAlign(a *aligner) {
+	a.restore()
+}
+
+// writePadding writes a comment line describing the padding between the end
+// of a struct field and the offset of the next field or the end of the struct
+// if there is a gap between them.
+func (d *dumpState) writePadding(end, offset uintptr) {
+	if offset <= end {
+		return
+	}
+	d.indent()
+	fmt.Fprintf(d.w, "/* offset=%d padding=%d */\n", end, offset-end)
+}
+
+// symbol returns the constant name representation of v if v is a value of
+// a named integer type with registered constant names or a String method
+// returning an identifier that can be referred to by the dump.
+func (d *dumpState) symbol(v reflect.Value) (string, bool) {
+	typ := v.Type()
+	if typ.PkgPath() == "" || !isInteger(typ.Kind()) {
+		return "", false
+	}
+
+	// The package is only qualified when a symbol is
+	// written so that unused packages are not imported.
+	table, ok := d.cs.symbols[typ]
+	if !ok {
+		if !d.cs.StringerConstants {
+			return "", false
+		}
+		sym, ok := stringerName(v)
+		if !ok {
+			return "", false
+		}
+		if !d.q.isLocal(typ.PkgPath(), pkgName(typ)) && !token.IsExported(sym) {
+			// The name could not be referred to from
+			// outside its package.
+			return "", false
+		}
+		return d.q.selector(typ.PkgPath(), pkgName(typ), sym), true
+	}
+	val := intBits(v)
+	if sym, ok := table.names[val]; ok {
+		return d.q.selector(typ.PkgPath(), pkgName(typ), sym), true
+	}
+	if !table.flags {
+		return "", false
+	}
+
+	// Decompose the value into flags, preferring larger
+	// flag values, and print them in ascending order.
+	var flags []uint64
+	rem := val
+	for _, f := range table.values {
+		if f != 0 && rem&f == f {
+			flags = append(flags, f)
+			rem &^= f
+		}
+	}
+	if len(flags) == 0 {
+		return "", false
+	}
+	pkg := d.q.qualify(typ.PkgPath(), pkgName(typ))
+	if pkg != "" {
+		pkg += "."
+	}
+	var buf bytes.Buffer
+	for i := len(flags) - 1; i >= 0; i-- {
+		if buf.Len() != 0 {
+			buf.WriteByte('|')
 		}
+		buf.WriteString(pkg)
+		buf.WriteString(table.names[flags[i]])
+	}
+	if rem != 0 {
+		buf.WriteByte('|')
+		buf.Write(d.typeBytes(typ))
+		buf.Write(openParenBytes)
+		d.printInteger(&buf, typ, rem)
+		buf.Write(closeParenBytes)
+	}
+	return buf.String(), true
+}
+
+// printInteger outputs the integer value with the bits val of type typ to
+// Writer w using the configured radix for the type.
+func (d *dumpState) printInteger(w io.Writer, typ reflect.Type, val uint64) {
+	var signed bool
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		signed = true
+	}
+	base, ok := d.cs.TypeRadix[typ]
+	if !ok || !isRadix(base) {
+		if signed {
+			base = d.cs.SignedRadix
+			if !isRadix(base) {
+				base = 10
+			}
+		} else {
+			base = d.cs.UnsignedRadix
+			if !isRadix(base) {
+				base = 16
+			}
+		}
+	}
+	neg := signed && int64(val) < 0
+	if neg {
+		val = -val
+	}
+	d.scratch = appendInteger(d.scratch[:0], val, neg, typ.Bits(), base, d.cs.GroupDigits, d.cs.PadDigits)
+	w.Write(d.scratch)
+}
+
+// writeHexPtr writes p to the dump as for appendHexPtr.
+func (d *dumpState) writeHexPtr(p uintptr, isPointer bool) {
+	d.scratch = appendHexPtr(d.scratch[:0], p, isPointer)
+	d.w.Write(d.scratch)
+}
+
+// isRadix returns whether base is a valid integer literal radix.
+func isRadix(base int) bool {
+	return base == 2 || base == 8 || base == 10 || base == 16
+}
+
+// stringerName returns the result of calling the String method of v if it
+// implements fmt.Stringer and the result is a valid Go identifier.
+func stringerName(v reflect.Value) (name string, ok bool) {
+	if !v.CanInterface() {
+		v = unsafeReflectValue(v)
 	}
+	s, ok := v.Interface().(fmt.Stringer)
+	if !ok && v.CanAddr() {
+		s, ok = v.Addr().Interface().(fmt.Stringer)
+	}
+	if !ok {
+		return "", false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			name, ok = "", false
+		}
+	}()
+	name = s.String()
+	return name, token.IsIdentifier(name)
 }
 
 // writeQuoted writes the string s quoted according to the quoting strategy.
 func (d *dumpState) writeQuoted(s string) {
-	switch d.cs.Quoting {
+	switch d.cs.Quoting &^ Multiline {
 	default:
 		fallthrough
 	case DoubleQuote:
-		d.w.Write([]byte(strconv.Quote(s)))
+		d.doubleQuote(s)
 
 	case AvoidEscapes:
 		if !needsEscape(s) || !canBackquoteString(s) {
-			d.w.Write([]byte(strconv.Quote(s)))
+			d.doubleQuote(s)
 			return
 		}
 		d.backQuote(s)
 
 	case AvoidEscapes | Force:
 		if !needsEscape(s) {
-			d.w.Write([]byte(strconv.Quote(s)))
+			d.doubleQuote(s)
 			return
 		}
 
@@ -627,7 +1529,7 @@ func (d *dumpState) writeQuoted(s string) {
 						d.backQuote(s[last:i])
 					}
 				} else {
-					d.w.Write([]byte(strconv.Quote(s[last:i])))
+					d.doubleQuote(s[last:i])
 				}
 				last = i
 				inBackquote = !inBackquote
@@ -638,7 +1540,7 @@ func (d *dumpState) writeQuoted(s string) {
 				d.w.Write(plusBytes)
 			}
 			if !inBackquote {
-				d.w.Write([]byte(strconv.Quote(s[last:])))
+				d.doubleQuote(s[last:])
 				return
 			}
 			d.backQuote(s[last:])
@@ -646,11 +1548,44 @@ func (d *dumpState) writeQuoted(s string) {
 	}
 }
 
+// doubleQuote writes s double quoted. If the Multiline quoting modifier is
+// set, s is split after each newline and written as a concatenation with
+// continuation lines indented one level deeper than the current depth.
+func (d *dumpState) doubleQuote(s string) {
+	if d.cs.Quoting&Multiline == 0 || !strings.Contains(strings.TrimSuffix(s, "\n"), "\n") {
+		d.scratch = strconv.AppendQuote(d.scratch[:0], s)
+		d.w.Write(d.scratch)
+		return
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	d.depth++
+	indent := d.indentBytes()
+	d.depth--
+	for i, line := range lines {
+		if i != 0 {
+			d.w.Write(concatNewlineBytes)
+			d.w.Write(indent)
+		}
+		d.scratch = strconv.AppendQuote(d.scratch[:0], line)
+		d.w.Write(d.scratch)
+	}
+}
+
 // backQuote writes s backquoted.
 func (d *dumpState) backQuote(s string) {
-	d.w.Write(backQuoteBytes)
-	d.w.Write([]byte(s))
-	d.w.Write(backQuoteBytes)
+	d.scratch = append(d.scratch[:0], backQuoteBytes...)
+	d.scratch = append(d.scratch, s...)
+	d.scratch = append(d.scratch, backQuoteBytes...)
+	d.w.Write(d.scratch)
+}
+
+// writeString writes s to the dump.
+func (d *dumpState) writeString(s string) {
+	d.scratch = append(d.scratch[:0], s...)
+	d.w.Write(d.scratch)
 }
 
 // needsEscape returns whether the string s needs any escape sequence to be
@@ -690,26 +1625,79 @@ func canBackquote(r rune) bool {
 	return (unicode.IsSpace(r) || ' ' < r) && r != '`' && r != '\u007f'
 }
 
-// typeString returns the string representation of the reflect.Type with the local
-// package selector removed.
-func typeString(typ reflect.Type, local string) string {
-	if typ.PkgPath() != "" {
-		return strings.TrimPrefix(strings.TrimPrefix(typ.String(), local), ".")
+// funcName returns the name of the function at pc with its package qualified
+// by q. Closures and method values are annotated with a comment.
+func funcName(pc uintptr, q *qualifier) string {
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return fmt.Sprintf("%#x", pc)
 	}
-	switch typ.Kind() {
-	case reflect.Ptr:
-		return "*" + strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(typ.String(), "*"), local), ".")
-	case reflect.Array:
-		return fmt.Sprintf("[%d]%s", typ.Len(), typeString(typ.Elem(), local))
-	case reflect.Chan:
-		return fmt.Sprintf("%s %s", typ.ChanDir(), typeString(typ.Elem(), local))
-	case reflect.Map:
-		return fmt.Sprintf("map[%s]%s", typeString(typ.Key(), local), typeString(typ.Elem(), local))
-	case reflect.Slice:
-		return fmt.Sprintf("[]%s", typeString(typ.Elem(), local))
-	default:
-		return strings.TrimPrefix(strings.TrimPrefix(typ.String(), local), ".")
+
+	// The runtime name is qualified by the import path of the package
+	// with dots in the last path element escaped. Separate the import
+	// path from the name. The package name is not held by the runtime,
+	// so it is guessed from the import path.
+	name := fn.Name()
+	var dir string
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		dir, name = name[:i+1], name[i+1:]
+	}
+	i := strings.Index(name, ".")
+	if i < 0 {
+		return name
+	}
+	path := dir + strings.Replace(name[:i], "%2e", ".", -1)
+	name = name[i+1:]
+
+	// Instantiations of generic functions and methods are named
+	// with elided type arguments, which are not valid Go, so the
+	// name is given without them.
+	var notes []string
+	if strings.Contains(name, "[...]") {
+		name = strings.Replace(name, "[...]", "", -1)
+		notes = append(notes, "generic instantiation")
 	}
+
+	// Function literals are named for their enclosing function
+	// so are never at the package level.
+	elems := strings.Split(name, ".")
+	switch {
+	case strings.HasSuffix(name, "-fm"):
+		name = strings.TrimSuffix(name, "-fm")
+		notes = append(notes, "method value")
+	case len(elems) > 1:
+		for _, elem := range elems[1:] {
+			if isClosureName(elem) {
+				notes = append(notes, "closure")
+				break
+			}
+		}
+	}
+	name = q.pathSelector(path, name)
+	if len(notes) != 0 {
+		name += " /* " + strings.Join(notes, ", ") + " */"
+	}
+	return name
+}
+
+// isClosureName returns whether the function name element is a name
+// generated by the compiler for a function literal.
+func isClosureName(elem string) bool {
+	for _, prefix := range []string{"func", "gowrap", "deferwrap"} {
+		if strings.HasPrefix(elem, prefix) {
+			elem = elem[len(prefix):]
+			break
+		}
+	}
+	if elem == "" {
+		return false
+	}
+	for _, r := range elem {
+		if r < '0' || '9' < r {
+			return false
+		}
+	}
+	return true
 }
 
 // isDefault returns whether the type is a default type absent of context.
@@ -739,17 +1727,195 @@ func isZero(v reflect.Value) bool {
 // fdump is a helper function to consolidate the logic from the various public
 // methods which take varying writers and config states.
 func fdump(cs *ConfigState, w io.Writer, a interface{}) {
+	fdumpQualified(context.Background(), cs, w, a, newQualifier(cs.TargetPackage, cs.LocalPackage))
+}
+
+var (
+	// ErrNodeBudget is returned when a dump is aborted because it
+	// visited more than MaxNodes values.
+	ErrNodeBudget = errors.New("utter: node budget exceeded")
+
+	// ErrByteBudget is returned when a dump is aborted because it
+	// wrote more than MaxBytes bytes.
+	ErrByteBudget = errors.New("utter: byte budget exceeded")
+)
+
+// dumpAbort is used to unwind the dump of a value when it is cancelled,
+// exceeds its budget or fails to write.
+type dumpAbort struct {
+	err error
+}
+
+// visit records the visit of a value by the dump, aborting the dump if it
+// has been cancelled or has exceeded its budget.
+func (d *dumpState) visit() {
+	if d.errw != nil && d.errw.err != nil {
+		panic(dumpAbort{d.errw.err})
+	}
+	d.visited++
+	if d.cs.MaxNodes > 0 && d.visited > d.cs.MaxNodes {
+		panic(dumpAbort{ErrNodeBudget})
+	}
+	if d.limit != nil && d.limit.exceeded {
+		panic(dumpAbort{ErrByteBudget})
+	}
+	d.checkDone()
+}
+
+// checkDone aborts the dump if its context has been cancelled.
+func (d *dumpState) checkDone() {
+	if d.ctx == nil {
+		return
+	}
+	select {
+	case <-d.ctx.Done():
+		panic(dumpAbort{d.ctx.Err()})
+	default:
+	}
+}
+
+// limitWriter writes at most n bytes to w. Bytes beyond n are discarded.
+// The budget is exceeded when a write or the bytes held in buffers for
+// later writing would take the total past n.
+type limitWriter struct {
+	w        io.Writer
+	n        int
+	held     int
+	exceeded bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if n > l.n {
+		l.exceeded = true
+		p = p[:l.n]
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	l.n -= len(p)
+	_, err := l.w.Write(p)
+	return n, err
+}
+
+// hold records that n more bytes are held in buffers for later writing.
+func (l *limitWriter) hold(n int) {
+	if l == nil {
+		return
+	}
+	l.held += n
+	if l.held > l.n {
+		l.exceeded = true
+	}
+}
+
+// release records that n bytes held in buffers are no longer held.
+func (l *limitWriter) release(n int) {
+	if l == nil {
+		return
+	}
+	l.held -= n
+}
+
+// heldBuffer is a buffer holding output of a dump for later writing. The
+// bytes held are counted against the budget of limit if it is not nil.
+type heldBuffer struct {
+	bytes.Buffer
+	limit *limitWriter
+}
+
+func (b *heldBuffer) Write(p []byte) (int, error) {
+	b.limit.hold(len(p))
+	return b.Buffer.Write(p)
+}
+
+// errWriter writes to w until a write fails. The first error is retained
+// and all following writes are discarded.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
+
+// fdumpQualified is fdump with package names qualified by q and cancellation
+// by ctx. If the dump is aborted, the reason is written in a trailing comment
+// and returned. If the Gofmt option is set and a dump that was not aborted
+// cannot be formatted, the reason is also written in a trailing comment and
+// returned. Output is buffered before it is written to w. The dump is
+// stopped at the first error writing to w, and the error is returned.
+func fdumpQualified(ctx context.Context, cs *ConfigState, w io.Writer, a interface{}, q *qualifier) (err error) {
+	errw := &errWriter{w: w}
+	w = errw
 	if a == nil {
 		w.Write(interfaceBytes)
 		w.Write(openParenBytes)
 		w.Write(nilBytes)
 		w.Write(closeParenBytes)
 		w.Write(newlineBytes)
-		return
+		return errw.err
+	}
+
+	// Report write errors, including those of the
+	// formatted output, unless the dump was aborted.
+	defer func() {
+		if err == nil {
+			err = errw.err
+		}
+	}()
+	if _, ok := errw.w.(*bytes.Buffer); !ok {
+		// Dumps are written in many small writes, so
+		// buffer them unless they are already held
+		// in memory.
+		bw := bufio.NewWriter(w)
+		defer bw.Flush()
+		w = bw
+	}
+	if cs.Gofmt {
+		var buf bytes.Buffer
+		out := w
+		defer func() {
+			ferr := writeGofmt(out, &buf)
+			if ferr == nil || err != nil {
+				// Aborted dumps are not expected
+				// to be formatted.
+				return
+			}
+			fmt.Fprintf(out, "// dump not formatted: %v\n", ferr)
+			err = fmt.Errorf("utter: could not format dump: %w", ferr)
+		}()
+		w = &buf
+	}
+	d := dumpState{w: w, cs: cs, q: q, ctx: ctx, errw: errw}
+	if cs.MaxBytes > 0 {
+		d.limit = &limitWriter{w: w, n: cs.MaxBytes}
+		d.w = d.limit
 	}
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		abort, ok := r.(dumpAbort)
+		if !ok {
+			panic(r)
+		}
+		// Write as much of the output held for
+		// later writing as the budget allows.
+		d.flushHeld()
+		if abort.err != errw.err {
+			fmt.Fprintf(w, "\n// dump aborted: %v\n", abort.err)
+		}
+		err = abort.err
+	}()
 
-	d := dumpState{w: w, cs: cs}
-	d.pointers = make(map[uintptr]int)
 	v := reflect.ValueOf(a)
 	var addr uintptr
 	if v.CanAddr() {
@@ -759,9 +1925,34 @@ func fdump(cs *ConfigState, w io.Writer, a interface{}) {
 	if cs.CommentPointers {
 		d.nodes = make(map[addrType]struct{})
 		d.walk(v, false, false, false, addr)
+		// The walk visits the values that are dumped,
+		// so the node budget applies to each pass.
+		d.visited = 0
 	}
 	d.dump(v, false, false, false, addr)
 	d.w.Write(newlineBytes)
+	return errw.err
+}
+
+// gofmtPrefix is prepended to dumps so that they can be formatted as the
+// value of a variable declaration.
+const gofmtPrefix = "package p\n\nvar v = "
+
+// writeGofmt writes the dump held in buf to w after formatting it with
+// gofmt. If the dump cannot be formatted, it is written unaltered and the
+// reason is returned.
+func writeGofmt(w io.Writer, buf *bytes.Buffer) error {
+	b := buf.Bytes()
+	src, err := format.Source(append([]byte(gofmtPrefix), b...))
+	if err == nil && !bytes.HasPrefix(src, []byte(gofmtPrefix)) {
+		err = errors.New("formatted dump is not a variable value")
+	}
+	if err != nil {
+		w.Write(b)
+		return err
+	}
+	w.Write(src[len(gofmtPrefix):])
+	return nil
 }
 
 // Fdump formats and displays the passed arguments to io.Writer w.  It formats
@@ -770,6 +1961,23 @@ func Fdump(w io.Writer, a interface{}) {
 	fdump(&Config, w, a)
 }
 
+// FdumpContext formats and displays the passed arguments to io.Writer w
+// in the same way as Fdump. The dump is aborted if ctx is cancelled or the
+// dump exceeds the MaxNodes or MaxBytes budgets. When a dump is aborted,
+// a comment explaining why is written and the reason is returned. Errors
+// writing to w are returned as for FdumpErr.
+func FdumpContext(ctx context.Context, w io.Writer, a interface{}) error {
+	return Config.FdumpContext(ctx, w, a)
+}
+
+// FdumpErr formats and displays the passed arguments to io.Writer w in the
+// same way as Fdump. The dump is stopped at the first error writing to w,
+// and the error is returned. If the Gofmt option is set and the dump cannot
+// be formatted, the formatting error is returned.
+func FdumpErr(w io.Writer, a interface{}) error {
+	return Config.FdumpErr(w, a)
+}
+
 // Sdump returns a string with the passed arguments formatted exactly the same
 // as Dump.
 func Sdump(a interface{}) string {
@@ -65,7 +65,12 @@ package utter_test
 import (
 	"bytes"
 	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
 	"math"
+	"os"
 	"testing"
 	"unsafe"
 
@@ -830,9 +835,11 @@ func addCircularDumpTests() {
 	addDumpTest(&pv3, "&&"+v3t+v3s2+"\n")
 }
 
-// TestDump executes all of the tests described by dumpTests.
-func TestDump(t *testing.T) {
-	// Setup tests.
+// setupDumpTests populates dumpTests if it has not already been populated.
+func setupDumpTests() {
+	if len(dumpTests) != 0 {
+		return
+	}
 	addIntDumpTests()
 	addUintDumpTests()
 	addBoolDumpTests()
@@ -850,6 +857,12 @@ func TestDump(t *testing.T) {
 	addFuncDumpTests()
 	addCircularDumpTests()
 	addCgoDumpTests()
+}
+
+// TestDump executes all of the tests described by dumpTests.
+func TestDump(t *testing.T) {
+	// Setup tests.
+	setupDumpTests()
 
 	t.Logf("Running %d tests", len(dumpTests))
 	for i, test := range dumpTests {
@@ -863,6 +876,32 @@ func TestDump(t *testing.T) {
 	}
 }
 
+// TestDumpGofmt ensures that all of the dump tests produce syntactically
+// valid Go that is unchanged by gofmt when the Gofmt option is set.
+func TestDumpGofmt(t *testing.T) {
+	setupDumpTests()
+
+	cfg := utter.NewDefaultConfig()
+	cfg.Gofmt = true
+	for i, test := range dumpTests {
+		s := cfg.Sdump(test.in)
+		src := "package p\n\nvar v = " + s
+		_, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ParseComments)
+		if err != nil {
+			t.Errorf("Dump #%d: invalid syntax: %v\n%s", i, err, s)
+			continue
+		}
+		got, err := format.Source([]byte(src))
+		if err != nil {
+			t.Errorf("Dump #%d: unexpected format error: %v\n%s", i, err, s)
+			continue
+		}
+		if string(got) != src {
+			t.Errorf("Dump #%d: output not gofmt clean:\ngot:\n%s\nwant:\n%s", i, s, got[len("package p\n\nvar v = "):])
+		}
+	}
+}
+
 func TestDumpOmitZero(t *testing.T) {
 	cfg := utter.ConfigState{OmitZero: true, SortKeys: true}
 	type sub struct {
@@ -1215,3 +1254,101 @@ func TestIssue5Maps(t *testing.T) {
 		}()
 	}
 }
+
+// benchItem is a nested struct for benchmarking dumps.
+type benchItem struct {
+	Name  string
+	ID    uint64
+	Score float64
+	Tags  []string
+	Attrs map[string]int
+	Inner *benchItem
+}
+
+// benchItems returns n nested struct values for benchmarking dumps.
+func benchItems(n int) []benchItem {
+	items := make([]benchItem, n)
+	for i := range items {
+		items[i] = benchItem{
+			Name:  fmt.Sprintf("item%d", i),
+			ID:    uint64(i) << 20,
+			Score: float64(i) / 3,
+			Tags:  []string{"a", "b", "c"},
+			Attrs: map[string]int{"x": i, "y": -i},
+			Inner: &benchItem{Name: "inner", ID: uint64(i)},
+		}
+	}
+	return items
+}
+
+var benchmarkDumps = []struct {
+	name string
+	v    interface{}
+}{
+	{name: "ints", v: func() []int {
+		s := make([]int, 1e4)
+		for i := range s {
+			s[i] = i * 7919
+		}
+		return s
+	}()},
+	{name: "bytes", v: bytes.Repeat([]byte("utter hex dump\x00\x01"), 4096)},
+	{name: "floats", v: func() []float64 {
+		s := make([]float64, 1e4)
+		for i := range s {
+			s[i] = float64(i) / 7
+		}
+		return s
+	}()},
+	{name: "structs", v: benchItems(1000)},
+}
+
+func BenchmarkSdump(b *testing.B) {
+	cfg := utter.NewDefaultConfig()
+	cfg.SortKeys = true
+	for _, bench := range benchmarkDumps {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(cfg.Sdump(bench.v))))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cfg.Sdump(bench.v)
+			}
+		})
+	}
+}
+
+func BenchmarkFdumpDiscard(b *testing.B) {
+	cfg := utter.NewDefaultConfig()
+	cfg.SortKeys = true
+	for _, bench := range benchmarkDumps {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(cfg.Sdump(bench.v))))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cfg.Fdump(io.Discard, bench.v)
+			}
+		})
+	}
+}
+
+func BenchmarkFdumpFile(b *testing.B) {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	cfg := utter.NewDefaultConfig()
+	cfg.SortKeys = true
+	for _, bench := range benchmarkDumps {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(cfg.Sdump(bench.v))))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cfg.Fdump(f, bench.v)
+			}
+		})
+	}
+}
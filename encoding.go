@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2026 Dan Kortschak <dan.kortschak@adelaide.edu.au>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+)
+
+// MustBase64 returns the bytes encoded by the standard base64 encoding
+// in s. It panics if s is not a valid encoding. MustBase64 is used in
+// the output of dumps using the Base64Bytes and GzipBytes encodings.
+func MustBase64(s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// MustGzipBase64 returns the bytes of the gzip stream encoded by the
+// standard base64 encoding in s. It panics if s is not a valid encoding
+// or the stream cannot be decompressed. MustGzipBase64 is used in the
+// output of dumps using the GzipBytes encoding.
+func MustGzipBase64(s string) []byte {
+	r, err := gzip.NewReader(bytes.NewReader(MustBase64(s)))
+	if err != nil {
+		panic(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// gzipBase64 returns the standard base64 encoding of the gzip
+// compressed bytes of b.
+func gzipBase64(b []byte) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2015 Dan Kortschak <dan.kortschak@adelaide.edu.au>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utter_test
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"testing"
+	"text/template"
+
+	"github.com/kortschak/utter"
+)
+
+// box is a generic type for testing instantiated type names.
+type box[T any] struct {
+	V T
+}
+
+// entry is a generic type with multiple type parameters.
+type entry[K comparable, V any] struct {
+	K K
+	V V
+}
+
+// TestGenericTypes checks that the type arguments of instantiated generic
+// types are qualified in the same way as other types.
+func TestGenericTypes(t *testing.T) {
+	def := utter.NewDefaultConfig()
+
+	local := utter.NewDefaultConfig()
+	local.LocalPackage = "utter_test"
+
+	target := utter.NewDefaultConfig()
+	target.TargetPackage = "github.com/kortschak/utter_test"
+
+	tests := []struct {
+		cfg  *utter.ConfigState
+		in   interface{}
+		want string
+	}{
+		{def, box[int]{V: 1}, "utter_test.box[int]{\n V: int(1),\n}\n"},
+		{def, box[Flag]{}, "utter_test.box[utter_test.Flag]{\n V: utter_test.Flag(0),\n}\n"},
+		{local, box[Flag]{}, "box[Flag]{\n V: Flag(0),\n}\n"},
+		{target, box[*template.Template]{},
+			"box[*template.Template]{\n V: (*template.Template)(nil),\n}\n"},
+		{target, box[box[[]Flag]]{},
+			"box[box[[]Flag]]{\n V: box[[]Flag]{\n  V: []Flag(nil),\n },\n}\n"},
+		{target, entry[string, map[Flag]*htmltemplate.Template]{},
+			"entry[string, map[Flag]*template.Template]{\n K: string(\"\"),\n V: map[Flag]*template.Template(nil),\n}\n"},
+		{target, entry[*template.Template, box[*htmltemplate.Template]]{},
+			"entry[*template.Template, box[*template2.Template]]{\n" +
+				" K: (*template.Template)(nil),\n V: box[*template2.Template]{\n  V: (*template2.Template)(nil),\n },\n}\n"},
+		{target, box[struct {
+			F func(int) (Flag, error) `json:"f,omitempty"`
+		}]{},
+			"box[struct { F func(int) (Flag, error) \"json:\\\"f,omitempty\\\"\" }]{\n" +
+				" V: struct { F func(int) (Flag, error) \"json:\\\"f,omitempty\\\"\" }{\n  F: func(int) (Flag, error)(nil),\n },\n}\n"},
+	}
+	for i, test := range tests {
+		if got := test.cfg.Sdump(test.in); got != test.want {
+			t.Errorf("test %d: unexpected dump:\ngot:\n%s\nwant:\n%s", i, got, test.want)
+		}
+	}
+}
+
+// TestGenericTypesDecl checks that declarations of instantiated generic
+// types import the packages of their type arguments.
+func TestGenericTypesDecl(t *testing.T) {
+	cfg := utter.NewDefaultConfig()
+	cfg.TargetPackage = "github.com/kortschak/utter_test"
+
+	want := `import (
+	template2 "html/template"
+	"text/template"
+)
+
+var want = entry[*template.Template, *template2.Template]{
+	K: (*template.Template)(nil),
+	V: (*template2.Template)(nil),
+}
+`
+	if got := cfg.SdumpDecl("want", entry[*template.Template, *htmltemplate.Template]{}); got != want {
+		t.Errorf("unexpected declaration:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestPtr checks that Ptr returns a pointer to its argument's value.
+func TestPtr(t *testing.T) {
+	if got := *utter.Ptr(5); got != 5 {
+		t.Errorf("unexpected pointee: got:%d want:5", got)
+	}
+	p := utter.Ptr[interface{}]("x")
+	if got := *p; got != "x" {
+		t.Errorf("unexpected pointee: got:%v want:x", got)
+	}
+}
+
+// ident is a generic function for testing func names.
+func ident[T any](v T) T { return v }
+
+// get is a generic method for testing method value names.
+func (b box[T]) get() T { return b.V }
+
+// TestGenericFuncNames checks that the names of instantiated generic
+// functions and methods are valid Go.
+func TestGenericFuncNames(t *testing.T) {
+	cfg := utter.NewDefaultConfig()
+	cfg.Gofmt = true
+	cfg.FuncNames = true
+
+	var b box[int]
+	var buf bytes.Buffer
+	err := cfg.FdumpErr(&buf, []interface{}{ident[int], b.get})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "[]interface{}{\n" +
+		"\t(func(int) int)(utter_test.ident /* generic instantiation */),\n" +
+		"\t(func() int)(utter_test.box.get /* generic instantiation, method value */),\n}\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected dump:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -25,8 +25,10 @@ package utter
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 	"unsafe"
 )
 
@@ -115,5 +117,106 @@ func TestAddedReflectValue(t *testing.T) {
 // SortMapByKeyVals makes the internal sortMapByKeyVals function available
 // to the test package.
 func SortMapByKeyVals(keys, vals []reflect.Value) {
-	sortMapByKeyVals(keys, vals)
+	sortMapByKeyVals(keys, vals, nil)
+}
+
+// TestChanLayout checks that the layout of the runtime's channel header found
+// by the probe in init agrees with the queues of channels in a range of states.
+// If this fails, the runtime's channel header has changed and chanElems must
+// be updated.
+func TestChanLayout(t *testing.T) {
+	if chanRecvxOffset == 0 {
+		t.Fatal("failed to find the layout of the runtime's channel header")
+	}
+	for size := 1; size <= 4; size++ {
+		for rotate := 0; rotate < size; rotate++ {
+			for n := 0; n <= size; n++ {
+				c := make(chan int, size)
+				for i := 0; i < rotate; i++ {
+					c <- -1
+					<-c
+				}
+				for i := 0; i < n; i++ {
+					c <- i
+				}
+
+				elems, ok := chanElems(reflect.ValueOf(c))
+				if !ok {
+					t.Fatalf("size=%d rotate=%d n=%d: failed to get channel elements", size, rotate, n)
+				}
+				if len(elems) != n {
+					t.Fatalf("size=%d rotate=%d n=%d: unexpected number of elements: got:%d want:%d",
+						size, rotate, n, len(elems), n)
+				}
+				for i, e := range elems {
+					if got, want := e.Int(), int64(<-c); got != want {
+						t.Fatalf("size=%d rotate=%d n=%d: unexpected element %d: got:%d want:%d",
+							size, rotate, n, i, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestQualifierImports checks that packages whose names are guessed from
+// their import paths are imported with an alias and that the names of local
+// variables in generated code are not used for packages.
+func TestQualifierImports(t *testing.T) {
+	q := newQualifier("", "")
+	args := q.typeArgs("[github.com/mattn/go-sqlite3.Conn,example.com/c.T,example.com/v.T,example/foo-bar.T,example.com/p1.T,example.com/p.T,strings.Builder]")
+	if want := "[go_sqlite3.Conn, c2.T, v2.T, foo_bar.T, p1_.T, p.T, strings.Builder]"; args != want {
+		t.Errorf("unexpected type arguments: got:%s want:%s", args, want)
+	}
+	decl := fmt.Sprintf("var x T%s\n", args)
+
+	var buf bytes.Buffer
+	writeImports(&buf, importPaths([]byte(decl), q), q)
+	want := `import (
+	"strings"
+
+	c2 "example.com/c"
+	p "example.com/p"
+	p1_ "example.com/p1"
+	v2 "example.com/v"
+	foo_bar "example/foo-bar"
+	go_sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected imports:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestQualifierLocal checks that a trailing dot in a local package name is
+// ignored.
+func TestQualifierLocal(t *testing.T) {
+	for _, local := range []string{"main", "main."} {
+		q := newQualifier("", local)
+		if got := q.selector("example.com/cmd", "main", "T"); got != "T" {
+			t.Errorf("unexpected selector for local package %q: got:%s want:T", local, got)
+		}
+	}
+}
+
+// TestSymbolQualify checks that packages are only qualified by symbol when a
+// symbol is written.
+func TestSymbolQualify(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.RegisterEnum(map[time.Weekday]string{time.Sunday: "Sunday"})
+	d := dumpState{cs: cfg, q: newQualifier("", "")}
+
+	if _, ok := d.symbol(reflect.ValueOf(time.March)); ok {
+		t.Error("unexpected symbol for unregistered type")
+	}
+	if _, ok := d.symbol(reflect.ValueOf(time.Monday)); ok {
+		t.Error("unexpected symbol for unregistered value")
+	}
+	if len(d.q.names) != 0 {
+		t.Errorf("unexpected qualified packages: %v", d.q.names)
+	}
+	if got, ok := d.symbol(reflect.ValueOf(time.Sunday)); !ok || got != "time.Sunday" {
+		t.Errorf("unexpected symbol: got:%q want:%q", got, "time.Sunday")
+	}
 }
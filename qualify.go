@@ -0,0 +1,362 @@
+/*
+ * Copyright (c) 2026 Dan Kortschak <dan.kortschak@adelaide.edu.au>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utter
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// utterPath is the import path of this package.
+const utterPath = "github.com/kortschak/utter"
+
+// qualifier determines how packages are referred to in a dump. Packages are
+// identified by their import path, in the manner of go/types.Qualifier, and
+// are given a unique name within the dump. Packages that share a name are
+// distinguished by an alias.
+type qualifier struct {
+	// target is the import path of the package the dump
+	// is relative to. Its identifiers are not qualified.
+	target string
+	// local is the name of a package whose identifiers
+	// are not qualified.
+	local string
+
+	// names maps import paths to the name used in the dump
+	// and pkgNames maps import paths to the package name
+	// when it is known rather than guessed from the path.
+	names    map[string]string
+	pkgNames map[string]string
+	// used maps names used in the dump to import paths.
+	used map[string]string
+}
+
+// newQualifier returns a qualifier for dumps relative to the package with
+// the import path target or the package name local. A trailing dot in local
+// is ignored for compatibility with selector prefixes.
+func newQualifier(target, local string) *qualifier {
+	return &qualifier{
+		target:   target,
+		local:    strings.TrimSuffix(local, "."),
+		names:    make(map[string]string),
+		pkgNames: make(map[string]string),
+		// The math package is referred to by name for
+		// special floating point values, and c and v are
+		// the names of local variables in function literal
+		// renderings of channels and pointers, so reserve
+		// them. The numbered pointer variables, p1 and so
+		// on, are unbounded so are avoided by qualifyName.
+		used: map[string]string{"math": "math", "c": "", "v": ""},
+	}
+}
+
+// qualify returns the name used to refer to the package with the given
+// import path and package name. It returns the empty string if identifiers
+// in the package are not qualified. A nil qualifier returns the package name.
+func (q *qualifier) qualify(path, name string) string {
+	return q.qualifyName(path, name, true)
+}
+
+// qualifyName returns the name used to refer to the package as described
+// for qualify. The package name is only recorded for use in imports if it
+// is known.
+func (q *qualifier) qualifyName(path, name string, known bool) string {
+	if q == nil {
+		return name
+	}
+	if q.isLocal(path, name) {
+		return ""
+	}
+	if n, ok := q.names[path]; ok {
+		if known {
+			q.pkgNames[path] = name
+		}
+		return n
+	}
+	n, base := name, name
+	if isPtrVar(name + "2") {
+		// Numbered names would be pointer
+		// variable names, so separate the
+		// number from the name.
+		base += "_"
+		if isPtrVar(name) {
+			n = base
+		}
+	}
+	for i := 2; ; i++ {
+		p, ok := q.used[n]
+		if !ok || p == path {
+			break
+		}
+		n = base + strconv.Itoa(i)
+	}
+	q.names[path] = n
+	if known {
+		q.pkgNames[path] = name
+	}
+	q.used[n] = path
+	return n
+}
+
+// isLocal returns whether identifiers in the package with the given import
+// path and package name are not qualified.
+func (q *qualifier) isLocal(path, name string) bool {
+	return q != nil && (path == q.target || name == q.local)
+}
+
+// selector returns ident qualified by the name used for the package with
+// the given import path and package name.
+func (q *qualifier) selector(path, name, ident string) string {
+	pkg := q.qualify(path, name)
+	if pkg == "" {
+		return ident
+	}
+	return pkg + "." + ident
+}
+
+// pathSelector returns ident qualified by the name used for the package
+// with the given import path when the package's name is not known. The
+// name is guessed from the path, so outside the standard library the
+// package is always imported with an alias.
+func (q *qualifier) pathSelector(path, ident string) string {
+	pkg := q.qualifyName(path, importName(path), isStdlib(path))
+	if pkg == "" {
+		return ident
+	}
+	return pkg + "." + ident
+}
+
+// alias returns the name used for the package with the given import path
+// if it differs from the package's name or the package's name is not known.
+func (q *qualifier) alias(path string) string {
+	if n, ok := q.pkgNames[path]; !ok || n != q.names[path] {
+		return q.names[path]
+	}
+	return ""
+}
+
+// isPtrVar returns whether name is the name of a numbered local variable in
+// function literal renderings of pointers.
+func isPtrVar(name string) bool {
+	if len(name) < 2 || name[0] != 'p' {
+		return false
+	}
+	for _, r := range name[1:] {
+		if r < '0' || '9' < r {
+			return false
+		}
+	}
+	return true
+}
+
+// pkgName returns the name of the package declaring the named type typ.
+func pkgName(typ reflect.Type) string {
+	s := typ.String()
+	return s[:strings.Index(s, ".")]
+}
+
+// typeString returns the string representation of the reflect.Type with
+// package names qualified by q.
+func (q *qualifier) typeString(typ reflect.Type) string {
+	if typ.Name() != "" {
+		if typ.PkgPath() == "" {
+			return typ.String()
+		}
+		name := typ.Name()
+		if i := strings.IndexByte(name, '['); i >= 0 {
+			// Instantiated generic types hold their type
+			// arguments qualified by full import paths.
+			name = name[:i] + q.typeArgs(name[i:])
+		}
+		return q.selector(typ.PkgPath(), pkgName(typ), name)
+	}
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return "*" + q.typeString(typ.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", typ.Len(), q.typeString(typ.Elem()))
+	case reflect.Slice:
+		return "[]" + q.typeString(typ.Elem())
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", q.typeString(typ.Key()), q.typeString(typ.Elem()))
+	case reflect.Chan:
+		elem := q.typeString(typ.Elem())
+		// A receive-only channel element of a bidirectional
+		// channel would otherwise bind to the outer chan.
+		if typ.ChanDir() == reflect.BothDir && typ.Elem().Name() == "" &&
+			typ.Elem().Kind() == reflect.Chan && typ.Elem().ChanDir() == reflect.RecvDir {
+			elem = "(" + elem + ")"
+		}
+		return fmt.Sprintf("%s %s", typ.ChanDir(), elem)
+	case reflect.Func:
+		return "func" + q.signature(typ)
+	case reflect.Struct:
+		if typ.NumField() == 0 {
+			return "struct {}"
+		}
+		fields := make([]string, typ.NumField())
+		for i := range fields {
+			f := typ.Field(i)
+			s := q.typeString(f.Type)
+			if !f.Anonymous {
+				s = f.Name + " " + s
+			}
+			if f.Tag != "" {
+				s += " " + strconv.Quote(string(f.Tag))
+			}
+			fields[i] = s
+		}
+		return "struct { " + strings.Join(fields, "; ") + " }"
+	case reflect.Interface:
+		if typ.NumMethod() == 0 {
+			return "interface {}"
+		}
+		methods := make([]string, typ.NumMethod())
+		for i := range methods {
+			m := typ.Method(i)
+			name := m.Name
+			if m.PkgPath != "" {
+				name = q.pathSelector(m.PkgPath, name)
+			}
+			methods[i] = name + q.signature(m.Type)
+		}
+		return "interface { " + strings.Join(methods, "; ") + " }"
+	default:
+		return typ.String()
+	}
+}
+
+// typeArgs returns the type argument list of an instantiated generic type
+// as given by reflect with the import path qualified identifiers replaced by
+// identifiers qualified by q. Type arguments are separated as by gofmt.
+func (q *qualifier) typeArgs(args string) string {
+	var buf strings.Builder
+	for i := 0; i < len(args); {
+		c := args[i]
+		switch {
+		case c == '"':
+			// Skip struct tags, which may hold any text.
+			j := i + 1
+			for j < len(args) && args[j] != '"' {
+				if args[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(args) {
+				j++
+			}
+			buf.WriteString(args[i:j])
+			i = j
+		case c == ',':
+			buf.WriteByte(',')
+			if i+1 < len(args) && args[i+1] != ' ' {
+				buf.WriteByte(' ')
+			}
+			i++
+		case isPathByte(c):
+			j := i
+			for j < len(args) && isPathByte(args[j]) {
+				j++
+			}
+			word := args[i:j]
+			if k := strings.LastIndexByte(word, '.'); k >= 0 {
+				path := word[:k]
+				word = q.pathSelector(path, word[k+1:])
+			}
+			buf.WriteString(word)
+			i = j
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	return buf.String()
+}
+
+// isPathByte returns whether c may be part of an import path qualified
+// identifier.
+func isPathByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '_' || c == '.' || c == '/' || c == '-' || c == '~' || c == '%' || c >= utf8.RuneSelf
+}
+
+// importName returns the conventional package name for the import path.
+// This is the last path element with any major version element or dotted
+// suffix removed.
+func importName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// isMajorVersion returns whether the path element is a module major version
+// suffix.
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' {
+		return false
+	}
+	for _, r := range elem[1:] {
+		if r < '0' || '9' < r {
+			return false
+		}
+	}
+	return true
+}
+
+// signature returns the parameter and result lists of the func type typ.
+func (q *qualifier) signature(typ reflect.Type) string {
+	var buf strings.Builder
+	buf.WriteByte('(')
+	for i := 0; i < typ.NumIn(); i++ {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if typ.IsVariadic() && i == typ.NumIn()-1 {
+			buf.WriteString("...")
+			buf.WriteString(q.typeString(typ.In(i).Elem()))
+		} else {
+			buf.WriteString(q.typeString(typ.In(i)))
+		}
+	}
+	buf.WriteByte(')')
+	switch typ.NumOut() {
+	case 0:
+	case 1:
+		buf.WriteByte(' ')
+		buf.WriteString(q.typeString(typ.Out(0)))
+	default:
+		buf.WriteString(" (")
+		for i := 0; i < typ.NumOut(); i++ {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(q.typeString(typ.Out(i)))
+		}
+		buf.WriteByte(')')
+	}
+	return buf.String()
+}
@@ -19,8 +19,19 @@ package utter_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"go/format"
+	htmltemplate "html/template"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"text/template"
+	"time"
 
 	"github.com/kortschak/utter"
 )
@@ -107,6 +118,143 @@ func initSpewTests() {
 	oneElideDefault.NumericWidth = 0
 	oneElideDefault.StringWidth = 0
 
+	// Struct layout comments.
+	layoutDefault := utter.NewDefaultConfig()
+	layoutDefault.CommentLayout = true
+
+	// Length comments.
+	lenDefault := utter.NewDefaultConfig()
+	lenDefault.CommentLengths = true
+
+	// Length comments with spare capacity.
+	spareDefault := utter.NewDefaultConfig()
+	spareDefault.CommentLengths = true
+	spareDefault.CommentSpare = true
+
+	// One line slice with length comments.
+	oneLenDefault := utter.NewDefaultConfig()
+	oneLenDefault.CommentLengths = true
+	oneLenDefault.NumericWidth = 0
+
+	// Buffered channel contents as comments.
+	chanComment := utter.NewDefaultConfig()
+	chanComment.ChanContents = utter.CommentChanContents
+
+	// Buffered channel contents as comments with gofmt.
+	chanCommentGofmt := utter.NewDefaultConfig()
+	chanCommentGofmt.ChanContents = utter.CommentChanContents
+	chanCommentGofmt.Gofmt = true
+
+	// Buffered channel contents by construction.
+	chanConstruct := utter.NewDefaultConfig()
+	chanConstruct.ChanContents = utter.ConstructChanContents
+
+	// Buffered channel contents by construction with gofmt.
+	chanConstructGofmt := utter.NewDefaultConfig()
+	chanConstructGofmt.ChanContents = utter.ConstructChanContents
+	chanConstructGofmt.Gofmt = true
+
+	// Func names.
+	funcNames := utter.NewDefaultConfig()
+	funcNames.FuncNames = true
+
+	// Func names with local package removed.
+	funcNamesLocal := utter.NewDefaultConfig()
+	funcNamesLocal.FuncNames = true
+	funcNamesLocal.LocalPackage = "utter_test"
+
+	// Constant names from String methods.
+	stringerConsts := utter.NewDefaultConfig()
+	stringerConsts.StringerConstants = true
+
+	// Constant names from String methods with local package removed.
+	stringerConstsLocal := utter.NewDefaultConfig()
+	stringerConstsLocal.StringerConstants = true
+	stringerConstsLocal.LocalPackage = "utter_test"
+
+	// Registered constant names.
+	registeredConsts := utter.NewDefaultConfig()
+	registeredConsts.ElideType = true
+	registeredConsts.RegisterEnum(map[Flag]string{flagOne: "flagOne"})
+	registeredConsts.RegisterFlags(map[perm]string{
+		permExec:  "permExec",
+		permWrite: "permWrite",
+		permRead:  "permRead",
+		permAll:   "permAll",
+	})
+
+	// Integer radixes.
+	radixDefault := utter.NewDefaultConfig()
+	radixDefault.ElideType = true
+	radixDefault.SignedRadix = 16
+	radixDefault.UnsignedRadix = 10
+	radixDefault.TypeRadix = map[reflect.Type]int{
+		reflect.TypeOf(perm(0)):  2,
+		reflect.TypeOf(int8(0)):  8,
+		reflect.TypeOf(uint8(0)): 3,
+	}
+
+	// Integer radixes with grouped and padded digits.
+	radixGroupPad := utter.NewDefaultConfig()
+	radixGroupPad.ElideType = true
+	radixGroupPad.GroupDigits = true
+	radixGroupPad.PadDigits = true
+	radixGroupPad.TypeRadix = map[reflect.Type]int{
+		reflect.TypeOf(perm(0)): 2,
+		reflect.TypeOf(int8(0)): 8,
+	}
+
+	// Special float values.
+	floatSpecials := utter.NewDefaultConfig()
+	floatSpecials.FloatSpecials = true
+
+	// Special float values with elided types.
+	floatSpecialsElide := utter.NewDefaultConfig()
+	floatSpecialsElide.FloatSpecials = true
+	floatSpecialsElide.ElideType = true
+
+	// Hexadecimal float values.
+	hexFloats := utter.NewDefaultConfig()
+	hexFloats.HexFloats = true
+	hexFloats.ElideType = true
+
+	// Complex values as complex calls.
+	complexCalls := utter.NewDefaultConfig()
+	complexCalls.ComplexCalls = true
+	complexCalls.ElideType = true
+
+	// Alternative byte slice encodings.
+	stringBytes := utter.NewDefaultConfig()
+	stringBytes.ByteEncoding = utter.StringBytes
+	stringBytes.ElideType = true
+	base64Bytes := utter.NewDefaultConfig()
+	base64Bytes.ByteEncoding = utter.Base64Bytes
+	gzipBytes := utter.NewDefaultConfig()
+	gzipBytes.ByteEncoding = utter.GzipBytes
+	gzipBytes.GzipThreshold = 8
+
+	// Rune annotations.
+	commentRunes := utter.NewDefaultConfig()
+	commentRunes.CommentRunes = true
+	commentRunes.NumericWidth = 3
+	commentRunes.ElideType = true
+
+	// Integer slices as hexadecimal words.
+	hexWords := utter.NewDefaultConfig()
+	hexWords.HexWords = true
+	hexWords.ByteOrder = binary.BigEndian
+	hexWordsAddr := utter.NewDefaultConfig()
+	hexWordsAddr.HexWords = true
+	hexWordsAddr.ByteOrder = binary.LittleEndian
+	hexWordsAddr.BytesWidth = 8
+	hexWordsAddr.AddressBytes = true
+	hexWordsRunes := utter.NewDefaultConfig()
+	hexWordsRunes.HexWords = true
+	hexWordsRunes.CommentRunes = true
+	hexWordsEnum := utter.NewDefaultConfig()
+	hexWordsEnum.HexWords = true
+	hexWordsEnum.RegisterEnum(map[Flag]string{flagOne: "flagOne"})
+
 	// Ignore unexported fields.
 	ignUnexDefault := utter.NewDefaultConfig()
 	ignUnexDefault.IgnoreUnexported = true
@@ -134,6 +282,66 @@ func initSpewTests() {
 	backquote.SortKeys = true
 	backquote.Quoting = utter.Backquote
 
+	// Multiline.
+	multiline := utter.NewDefaultConfig()
+	multiline.SortKeys = true
+	multiline.Quoting = utter.DoubleQuote | utter.Multiline
+	avoidEscapeMultiline := utter.NewDefaultConfig()
+	avoidEscapeMultiline.Quoting = utter.AvoidEscapes | utter.Multiline
+
+	// Sorted slices.
+	sortSlices := utter.NewDefaultConfig()
+	sortSlices.SortSlices = true
+	sortSlices.ElideType = true
+	sortTagged := utter.NewDefaultConfig()
+	sortTagged.ElideType = true
+
+	// User supplied key ordering.
+	keyLess := utter.NewDefaultConfig()
+	keyLess.SortKeys = true
+	keyLess.ElideType = true
+	keyLess.KeyLess = versionLess
+
+	// Aligned fields.
+	alignFields := utter.NewDefaultConfig()
+	alignFields.AlignFields = true
+	alignFields.SortKeys = true
+	alignFields.ElideType = true
+
+	// Gofmt clean output.
+	gofmtDefault := utter.NewDefaultConfig()
+	gofmtDefault.Gofmt = true
+	gofmtCycle := &gofmtItem{}
+	gofmtCycle.N = gofmtCycle
+	gofmtPtr := &gofmtItem{}
+
+	// Qualify types relative to the test package.
+	targetDefault := utter.NewDefaultConfig()
+	targetDefault.TargetPackage = "github.com/kortschak/utter_test"
+
+	// Omit or comment unexported fields of foreign types. Fields
+	// are only omitted when the target package is known.
+	omitForeign := utter.NewDefaultConfig()
+	omitForeign.UnexportedFields = utter.OmitForeignUnexported
+	omitForeignTarget := utter.NewDefaultConfig()
+	omitForeignTarget.UnexportedFields = utter.OmitForeignUnexported
+	omitForeignTarget.TargetPackage = "github.com/kortschak/utter_test"
+	commentForeign := utter.NewDefaultConfig()
+	commentForeign.UnexportedFields = utter.CommentForeignUnexported
+
+	// Render pointers as helper calls or function literals.
+	ptrHelper := utter.NewDefaultConfig()
+	ptrHelper.PointerStyle = utter.PtrHelper
+	ptrHelperGofmt := utter.NewDefaultConfig()
+	ptrHelperGofmt.PointerStyle = utter.PtrHelper
+	ptrHelperGofmt.Gofmt = true
+	ptrClosure := utter.NewDefaultConfig()
+	ptrClosure.PointerStyle = utter.PtrClosure
+	ptrInt := 5
+	ptrItem := &sortItem{"a", 1}
+	var ptrIface interface{} = 5
+	var ptrNil *int
+
 	var (
 		np  *int
 		nip = new(interface{})
@@ -166,6 +374,39 @@ func initSpewTests() {
 
 	type b map[string]interface{}
 
+	// Queued elements wrap around the end of the channel's buffer.
+	ch := make(chan int, 3)
+	ch <- 1
+	ch <- 2
+	ch <- 3
+	<-ch
+	<-ch
+	ch <- 4
+	ch <- 5
+	emptyCh := make(chan int, 1)
+
+	// Channels may hold themselves.
+	selfCh := make(chan interface{}, 1)
+	selfCh <- selfCh
+
+	// Closed channels retain their queued elements.
+	closedCh := make(chan int, 3)
+	closedCh <- 1
+	closedCh <- 2
+	close(closedCh)
+
+	// Elements of zero size occupy no space in the buffer.
+	zeroCh := make(chan struct{}, 3)
+	zeroCh <- struct{}{}
+	zeroCh <- struct{}{}
+
+	type padded struct {
+		a int8
+		b int32
+		c int16
+		d struct{}
+	}
+
 	utterTests = []utterTest{
 		{scsDefault, fCSFdump, int8(127), "int8(127)\n"},
 		{scsDefault, fCSSdump, uint8(64), "uint8(0x40)\n"},
@@ -242,6 +483,147 @@ func initSpewTests() {
 		{bsa8Default, fCSFdump, []byte{1, 2, 3, 4, 5, 0, 1}, "[]uint8{\n" +
 			" 0x0: 0x01, 0x02, 0x03, 0x04, 0x05, 0x00, 0x01, // |.......|\n}\n",
 		},
+		{layoutDefault, fCSFdump, padded{a: 1, b: 2, c: 3}, "utter_test.padded{ /* size=12 align=4 */\n" +
+			" a: int8(1), /* offset=0 size=1 */\n" +
+			" /* offset=1 padding=3 */\n" +
+			" b: int32(2), /* offset=4 size=4 */\n" +
+			" c: int16(3), /* offset=8 size=2 */\n" +
+			" d: struct {}{ /* size=0 align=1 */\n }, /* offset=10 size=0 */\n" +
+			" /* offset=10 padding=2 */\n}\n",
+		},
+		{lenDefault, fCSFdump, make([]int, 2, 4), "[]int{ /* len=2 cap=4 */\n int(0),\n int(0),\n}\n"},
+		{lenDefault, fCSFdump, map[int]int{1: 2}, "map[int]int{ /* len=1 */\n int(1): int(2),\n}\n"},
+		{lenDefault, fCSFdump, "short", "string(\"short\")\n"},
+		{lenDefault, fCSFdump, strings.Repeat("long", 20), "string(\"" + strings.Repeat("long", 20) + "\" /* len=80 */)\n"},
+		{lenDefault, fCSFdump, [2]int{}, "[2]int{\n int(0),\n int(0),\n}\n"},
+		{oneLenDefault, fCSFdump, []int{1, 2}, "[]int{ /* len=2 cap=2 */ int(1), int(2)}\n"},
+		{spareDefault, fCSFdump, []int{1, 2, 3, 4}[:2], "[]int{ /* len=2 cap=4 */\n int(1),\n int(2),\n // int(3),\n // int(4),\n}\n"},
+		{spareDefault, fCSFdump, []struct{ a int }{{1}, {2}}[:1], "[]struct { a int }{ /* len=1 cap=2 */\n" +
+			" struct { a int }{\n  a: int(1),\n },\n" +
+			" // struct { a int }{\n //  a: int(2),\n // },\n}\n",
+		},
+		{spareDefault, fCSFdump, func() []byte { b := make([]byte, 4); copy(b, "abcd"); return b[:2] }(), "[]uint8{ /* len=2 cap=4 */\n" +
+			" 0x61, 0x62, // |ab|\n" +
+			" // 0x63, 0x64, // |cd|\n}\n",
+		},
+		{chanComment, fCSFdump, ch, fmt.Sprintf("(chan int, 3 /* 3 elements */)(%p /* {int(3), int(4), int(5)} */)\n", ch)},
+		{chanComment, fCSFdump, emptyCh, fmt.Sprintf("(chan int, 1)(%p)\n", emptyCh)},
+		{chanComment, fCSFdump, selfCh, fmt.Sprintf("(chan interface{}, 1 /* 1 element */)(%p /* {"+
+			"(chan interface{}, 1 /* 1 element * /)(<already shown>)} */)\n", selfCh)},
+		{chanComment, fCSFdump, closedCh, fmt.Sprintf("(chan int, 3 /* 2 elements */)(%p /* {int(1), int(2)} */)\n", closedCh)},
+		{chanComment, fCSFdump, zeroCh, fmt.Sprintf("(chan struct {}, 3 /* 2 elements */)(%p /* {struct {}{}, struct {}{}} */)\n", zeroCh)},
+		{chanCommentGofmt, fCSFdump, ch, fmt.Sprintf("(chan int /* cap=3, 3 elements */)(%p /* {int(3), int(4), int(5)} */)\n", ch)},
+		{chanCommentGofmt, fCSFdump, struct{ C []chan int }{[]chan int{closedCh}}, fmt.Sprintf("struct{ C []chan int }{\n"+
+			"\tC: []chan int{\n\t\t(chan int /* cap=3, 2 elements */)(%p /* {int(1), int(2)} */),\n\t},\n}\n", closedCh)},
+		{chanConstruct, fCSFdump, ch, "func() chan int {\n c := make(chan int, 3)\n c <- int(3)\n c <- int(4)\n c <- int(5)\n return c\n}()\n"},
+		{chanConstruct, fCSFdump, struct{ c <-chan string }{make(chan string, 2)},
+			"struct { c <-chan string }{\n c: func() <-chan string {\n  c := make(chan string, 2)\n  return c\n }(),\n}\n",
+		},
+		{chanConstruct, fCSFdump, closedCh, "func() chan int {\n c := make(chan int, 3)\n c <- int(1)\n c <- int(2)\n return c\n}()\n"},
+		{chanConstruct, fCSFdump, zeroCh, "func() chan struct {} {\n c := make(chan struct {}, 3)\n" +
+			" c <- struct {}{\n }\n c <- struct {}{\n }\n return c\n}()\n",
+		},
+		{chanConstructGofmt, fCSFdump, selfCh, "func() chan interface{} {\n\tc := make(chan interface{}, 1)\n" +
+			"\tc <- (chan interface{} /* cap=1, 1 element */)(nil /* already shown */)\n\treturn c\n}()\n",
+		},
+		{funcNames, fCSFdump, strings.ToUpper, "func(string) string(strings.ToUpper)\n"},
+		{funcNames, fCSFdump, (func(string) string)(nil), "func(string) string(nil)\n"},
+		{funcNames, fCSFdump, TestSpew, "func(*testing.T)(utter_test.TestSpew)\n"},
+		{funcNamesLocal, fCSFdump, TestSpew, "func(*testing.T)(TestSpew)\n"},
+		{funcNames, fCSFdump, newClosure(), "func() int(utter_test.newClosure.func1 /* closure */)\n"},
+		{funcNames, fCSFdump, new(bytes.Buffer).Len, "func() int(bytes.(*Buffer).Len /* method value */)\n"},
+		{stringerConsts, fCSFdump, Foo{Bar{flag: 1}, nil},
+			"utter_test.Foo{\n unexportedField: utter_test.Bar{\n  flag: utter_test.Flag(1),\n  data: uintptr(0),\n },\n ExportedField: map[interface{}]interface{}(nil),\n}\n",
+		},
+		{stringerConstsLocal, fCSFdump, Foo{Bar{flag: 1}, nil},
+			"Foo{\n unexportedField: Bar{\n  flag: flagTwo,\n  data: uintptr(0),\n },\n ExportedField: map[interface{}]interface{}(nil),\n}\n",
+		},
+		{stringerConsts, fCSFdump, Flag(5), "utter_test.Flag(5)\n"},
+		{stringerConstsLocal, fCSFdump, func() *Flag { f := Flag(0); return &f }(), "&Flag(flagOne)\n"},
+		{stringerConsts, fCSFdump, time.March, "time.March\n"},
+		{stringerConsts, fCSFdump, []reflect.Kind{reflect.Int, reflect.Ptr, reflect.Map},
+			"[]reflect.Kind{\n reflect.Kind(0x2),\n reflect.Kind(0x16),\n reflect.Kind(0x15),\n}\n",
+		},
+		{stringerConsts, fCSFdump, panicer(1), "utter_test.panicer(1)\n"},
+		{registeredConsts, fCSFdump, []Flag{0, 1}, "[]utter_test.Flag{\n utter_test.flagOne,\n 1,\n}\n"},
+		{registeredConsts, fCSFdump, []perm{permAll | 8, permRead | permExec, 8, 0},
+			"[]utter_test.perm{\n utter_test.permAll|utter_test.perm(0x8),\n utter_test.permExec|utter_test.permRead,\n 0x8,\n 0x0,\n}\n",
+		},
+		{radixDefault, fCSFdump, []interface{}{-255, uint(255), perm(5), int8(-8), uint8(255), uint16(1000)},
+			"[]interface{}{\n -0xff,\n uint(255),\n utter_test.perm(0b101),\n int8(-0o10),\n uint8(255),\n uint16(1000),\n}\n",
+		},
+		{radixGroupPad, fCSFdump, []interface{}{-1234567, uint32(1), perm(5), int8(-8), uint16(0xabc), int64(math.MinInt64), uint64(math.MaxUint64)},
+			"[]interface{}{\n -1_234_567,\n uint32(0x0000_0001),\n utter_test.perm(0b0000_0000_0000_0101),\n int8(-0o010),\n uint16(0x0abc),\n" +
+				" int64(-9_223_372_036_854_775_808),\n uint64(0xffff_ffff_ffff_ffff),\n}\n",
+		},
+		{floatSpecials, fCSFdump, []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.Copysign(0, -1), 0, 1.5},
+			"[]float64{\n float64(math.NaN()),\n float64(math.Inf(1)),\n float64(math.Inf(-1)),\n float64(math.Copysign(0, -1)),\n float64(0),\n float64(1.5),\n}\n",
+		},
+		{floatSpecialsElide, fCSFdump, []float32{float32(math.NaN()), float32(math.Inf(-1)), 1},
+			"[]float32{\n float32(math.NaN()),\n float32(math.Inf(-1)),\n 1.0,\n}\n",
+		},
+		{floatSpecialsElide, fCSFdump, []float64{math.NaN(), math.Inf(1), 1},
+			"[]float64{\n math.NaN(),\n math.Inf(1),\n 1.0,\n}\n",
+		},
+		{floatSpecialsElide, fCSFdump, []celsius{celsius(math.NaN()), celsius(math.Inf(-1)), 1},
+			"[]utter_test.celsius{\n utter_test.celsius(math.NaN()),\n utter_test.celsius(math.Inf(-1)),\n 1.0,\n}\n",
+		},
+		{hexFloats, fCSFdump, []celsius{celsius(math.Float64frombits(0x7ff8000000000001)), celsius(math.Copysign(0, -1))},
+			"[]utter_test.celsius{\n utter_test.celsius(math.Float64frombits(0x7ff8000000000001)),\n utter_test.celsius(math.Copysign(0, -1)),\n}\n",
+		},
+		{hexFloats, fCSFdump, []interface{}{3.0, float32(-0.375), math.Float64frombits(0x7ff8000000000001), float32(math.NaN()), math.Inf(1), math.Copysign(0, -1)},
+			"[]interface{}{\n 0x1.8p+01,\n float32(-0x1.8p-02),\n math.Float64frombits(0x7ff8000000000001),\n float32(math.Float32frombits(0x7fc00000)),\n math.Inf(1),\n math.Copysign(0, -1),\n}\n",
+		},
+		{complexCalls, fCSFdump, []interface{}{
+			complex(1, 2),
+			complex(math.NaN(), 2),
+			complex64(complex(1, math.Inf(-1))),
+			[]complex64{complex(float32(math.Copysign(0, -1)), 2), 1 + 2i},
+		},
+			"[]interface{}{\n" +
+				" complex128(1+2i),\n" +
+				" complex128(complex(math.NaN(), 2.0)),\n" +
+				" complex64(complex(float32(1), float32(math.Inf(-1)))),\n" +
+				" []complex64{\n  complex(float32(math.Copysign(0, -1)), float32(2)),\n  1+2i,\n },\n}\n",
+		},
+		{hexFloats, fCSFdump, []complex128{1 + 2i, complex(math.Inf(1), -0.5)},
+			"[]complex128{\n 0x1p+00+0x1p+01i,\n complex(math.Inf(1), -0x1p-01),\n}\n",
+		},
+		{complexCalls, fCSFdump, []phasor{phasor(complex(math.NaN(), 1)), 1 + 2i},
+			"[]utter_test.phasor{\n utter_test.phasor(complex(float32(math.NaN()), float32(1))),\n 1+2i,\n}\n",
+		},
+		{stringBytes, fCSFdump, [][]byte{[]byte("hello\n"), {0, 1, 2, 3}, {}},
+			"[][]uint8{\n []uint8(\"hello\\n\"),\n {\n  0x00, 0x01, 0x02, 0x03, // |....|\n },\n {\n },\n}\n",
+		},
+		{stringBytes, fCSFdump, [2]byte{'h', 'i'},
+			"[2]uint8{\n 0x68, 0x69, // |hi|\n}\n",
+		},
+		{base64Bytes, fCSFdump, []byte("hello"),
+			"[]uint8(utter.MustBase64(\"aGVsbG8=\"))\n",
+		},
+		{gzipBytes, fCSFdump, []byte("hello"),
+			"[]uint8(utter.MustBase64(\"aGVsbG8=\"))\n",
+		},
+		{commentRunes, fCSFdump, []interface{}{"hé\xff!", ""},
+			"[]interface{}{\n \"hé\\xff!\" /* U+0068 Ll, U+00E9 Ll, invalid 0xff at 3, U+0021 Po */,\n \"\",\n}\n",
+		},
+		{commentRunes, fCSFdump, []rune{'a', 'É', ' ', '1', -1},
+			"[]int32{\n 97, 201, 32, // U+0061 Ll, U+00C9 Lu, U+0020 Zs\n 49, -1, // U+0031 Nd, invalid\n}\n",
+		},
+		{hexWords, fCSFdump, []uint16{0x4869, 0x2100, 1},
+			"[]uint16{\n 0x4869, 0x2100, 0x0001, // |Hi!...|\n}\n",
+		},
+		{hexWords, fCSFdump, [3]int16{-1, 0x41, 0x4243},
+			"[3]int16{\n -0x0001, +0x0041, +0x4243, // |...ABC|\n}\n",
+		},
+		{hexWordsAddr, fCSFdump, []uint32{0x64636261, 0x68676665, 0x6a69},
+			"[]uint32{\n 0x0: 0x64636261, 0x68676665, // |abcdefgh|\n 0x2: 0x00006a69, /*       */ // |ij..|\n}\n",
+		},
+		{hexWordsRunes, fCSFdump, []rune("hi"), "[]int32{\n int32(104), // U+0068 Ll\n int32(105), // U+0069 Ll\n}\n"},
+		{hexWordsEnum, fCSFdump, []Flag{flagOne, 5}, "[]utter_test.Flag{\n utter_test.flagOne,\n utter_test.Flag(5),\n}\n"},
+		{hexWordsAddr, fCSFdump, []uint64{0x4142},
+			"[]uint64{\n 0x0: 0x0000000000004142, // |BA......|\n}\n",
+		},
 		{ignUnexDefault, fCSFdump, Foo{Bar{flag: 1}, map[interface{}]interface{}{"one": true}},
 			"utter_test.Foo{\n ExportedField: map[interface{}]interface{}{\n  string(\"one\"): bool(true),\n },\n}\n",
 		},
@@ -340,6 +722,502 @@ func initSpewTests() {
 			"backquotetabbackquote": "`\t`",
 			"codeblock":             "```\ncode\n```\n",
 		}, "map[string]string{\n string(`\nt\nw\no\n`): string(`two`),\n string(`backquote`): string(\"`\"),\n string(`backquotetab`): string(\"`\"+`\t`),\n string(`backquotetabbackquote`): string(\"`\"+`\t`+\"`\"),\n string(`codeblock`): string(\"```\"+`\ncode\n`+\"```\"+`\n`),\n string(`one`): string(`\no\nn\ne\n`),\n string(`tabbackquote`): string(`\t`+\"`\"),\n string(`tabbackquotetab`): string(`\t`+\"`\"+`\t`),\n string(`three`): string(\"`\"+`t\th\tr\te\te`+\"`\"),\n}\n"},
+		{multiline, fCSFdump, map[string]string{
+			"a": "one\ntwo\nthree\n",
+			"b": "x\n",
+			"c": "\n",
+		}, "map[string]string{\n string(\"a\"): string(\"one\\n\" +\n  \"two\\n\" +\n  \"three\\n\"),\n string(\"b\"): string(\"x\\n\"),\n string(\"c\"): string(\"\\n\"),\n}\n"},
+		{avoidEscapeMultiline, fCSFdump, []string{"a\x00\nb", "c\nd"},
+			"[]string{\n string(\"a\\x00\\n\" +\n  \"b\"),\n string(`c\nd`),\n}\n"},
+		{sortTagged, fCSFdump, sortTag{A: []int{3, 1, 2}, B: []int{3, 1, 2}, P: &[]string{"b", "a"}},
+			"utter_test.sortTag{\n A: []int{\n  1,\n  2,\n  3,\n },\n B: []int{\n  3,\n  1,\n  2,\n },\n P: &[]string{\n  \"a\",\n  \"b\",\n },\n}\n"},
+		{sortSlices, fCSFdump, [][]int{{2}, {1, 2}, {1}},
+			"[][]int{\n {\n  1,\n },\n {\n  1,\n  2,\n },\n {\n  2,\n },\n}\n"},
+		{sortSlices, fCSFdump, []sortItem{{"b", 1}, {"a", 2}, {"a", 1}},
+			"[]utter_test.sortItem{\n {\n  Name: \"a\",\n  N: 1,\n },\n {\n  Name: \"a\",\n  N: 2,\n },\n {\n  Name: \"b\",\n  N: 1,\n },\n}\n"},
+		{sortSlices, fCSFdump, []map[string]int{{"b": 1}, {"a": 2}, {"a": 1}},
+			"[]map[string]int{\n {\n  \"a\": 1,\n },\n {\n  \"a\": 2,\n },\n {\n  \"b\": 1,\n },\n}\n"},
+		{sortSlices, fCSFdump, []byte{3, 1, 2},
+			"[]uint8{\n 0x01, 0x02, 0x03, // |...|\n}\n"},
+		{keyLess, fCSFdump, map[string]int{"v1.10.0": 1, "v1.2.0": 2, "v1.9.1": 3, "dev": 4},
+			"map[string]int{\n \"dev\": 4,\n \"v1.2.0\": 2,\n \"v1.9.1\": 3,\n \"v1.10.0\": 1,\n}\n"},
+		{alignFields, fCSFdump, alignItem{Short: 1, Inner: sortItem{"a", 1}, Longest: 3},
+			"utter_test.alignItem{\n Short:  1,\n Longer: \"\",\n Inner: utter_test.sortItem{\n  Name: \"a\",\n  N:    1,\n },\n Longest: 3,\n X:       0,\n}\n"},
+		{alignFields, fCSFdump, map[string]int{"a": 1, "bbbb": 2, "cc": 3},
+			"map[string]int{\n \"a\":    1,\n \"bbbb\": 2,\n \"cc\":   3,\n}\n"},
+		{gofmtDefault, fCSFdump, gofmtCycle,
+			"&utter_test.gofmtItem{\n\tN: (*utter_test.gofmtItem)(nil /* already shown */),\n\tF: (func())(nil),\n\tC: (<-chan int)(nil),\n}\n"},
+		{gofmtDefault, fCSFdump, &gofmtPtr,
+			"& &utter_test.gofmtItem{\n\tN: (*utter_test.gofmtItem)(nil),\n\tF: (func())(nil),\n\tC: (<-chan int)(nil),\n}\n"},
+		{omitForeign, fCSFdump, Foo{Bar{flag: 1}, nil},
+			"utter_test.Foo{\n ExportedField: map[interface{}]interface{}(nil),\n" +
+				" // unexportedField: utter_test.Bar{\n //  flag: utter_test.Flag(1),\n //  data: uintptr(0),\n // },\n}\n"},
+		{omitForeignTarget, fCSFdump, unexportedItem{T: time.Unix(0, 0), b: Bar{flag: 1}},
+			"unexportedItem{\n T: time.Time{\n },\n b: Bar{\n  flag: Flag(1),\n  data: uintptr(0),\n },\n}\n"},
+		{commentForeign, fCSFdump, Foo{Bar{flag: 1}, nil},
+			"utter_test.Foo{\n ExportedField: map[interface{}]interface{}(nil),\n" +
+				" // unexportedField: utter_test.Bar{\n //  flag: utter_test.Flag(1),\n //  data: uintptr(0),\n // },\n}\n"},
+		{ptrHelper, fCSFdump, &ptrInt, "utter.Ptr(int(5))\n"},
+		{ptrHelper, fCSFdump, []**int{func() **int { p := &ptrInt; return &p }()},
+			"[]**int{\n utter.Ptr(utter.Ptr(int(5))),\n}\n"},
+		{ptrHelper, fCSFdump, []*int{&ptrInt, &ptrInt},
+			"[]*int{\n utter.Ptr(int(5)),\n (*int)(<already shown>),\n}\n"},
+		{ptrHelperGofmt, fCSFdump, []*int{&ptrInt, &ptrInt},
+			"[]*int{\n\tutter.Ptr(int(5)),\n\t(*int)(nil /* already shown */),\n}\n"},
+		{ptrHelper, fCSFdump, ptrItem, "&utter_test.sortItem{\n Name: string(\"a\"),\n N: int(1),\n}\n"},
+		{ptrHelper, fCSFdump, &ptrItem, "utter.Ptr(&utter_test.sortItem{\n Name: string(\"a\"),\n N: int(1),\n})\n"},
+		{ptrHelper, fCSFdump, &ptrIface, "utter.Ptr[interface{}](int(5))\n"},
+		{ptrHelper, fCSFdump, &ptrNil, "utter.Ptr((*int)(nil))\n"},
+		{ptrClosure, fCSFdump, &ptrInt, "func() *int {\n v := int(5)\n return &v\n}()\n"},
+		{ptrClosure, fCSFdump, []**int{func() **int { p := &ptrInt; return &p }()},
+			"[]**int{\n func() **int {\n  v := int(5)\n  p1 := &v\n  return &p1\n }(),\n}\n"},
+		{ptrClosure, fCSFdump, &ptrItem,
+			"func() **utter_test.sortItem {\n v := &utter_test.sortItem{\n  Name: string(\"a\"),\n  N: int(1),\n }\n return &v\n}()\n"},
+		{ptrClosure, fCSFdump, &ptrIface, "func() *interface{} {\n var v interface{} = int(5)\n return &v\n}()\n"},
+		{targetDefault, fCSFdump, Flag(1), "Flag(1)\n"},
+		{targetDefault, fCSFdump, qualifyItem{},
+			"qualifyItem{\n T: (*template.Template)(nil),\n H: (*template2.Template)(nil),\n" +
+				" S: []struct { Flag; F *template.Template \"json:\\\"f\\\"\" }(nil),\n" +
+				" I: interface { Lookup(string) *template2.Template }(nil),\n" +
+				" C: chan (<-chan Flag)(nil),\n}\n"},
+		{targetDefault, fCSFdump, (func(Flag, ...*template.Template) (Flag, error))(nil),
+			"func(Flag, ...*template.Template) (Flag, error)(nil)\n"},
+	}
+}
+
+// unexportedItem is a struct for testing unexported fields of foreign types.
+type unexportedItem struct {
+	T time.Time
+	b Bar
+}
+
+// qualifyItem is a struct for testing package qualification.
+type qualifyItem struct {
+	T *template.Template
+	H *htmltemplate.Template
+	S []struct {
+		Flag
+		F *template.Template `json:"f"`
+	}
+	I interface {
+		Lookup(string) *htmltemplate.Template
+	}
+	C chan (<-chan Flag)
+}
+
+// gofmtItem is a struct for testing gofmt clean output.
+type gofmtItem struct {
+	N *gofmtItem
+	F func()
+	C <-chan int
+}
+
+// alignItem is a struct for testing field alignment.
+type alignItem struct {
+	Short   int
+	Longer  string
+	Inner   sortItem
+	Longest int
+	X       int
+}
+
+// TestAlignFieldsGofmt checks that aligned dumps are unchanged by gofmt.
+func TestAlignFieldsGofmt(t *testing.T) {
+	cfg := utter.NewDefaultConfig()
+	cfg.AlignFields = true
+	cfg.SortKeys = true
+	cfg.ElideType = true
+	cfg.Indent = "\t"
+
+	type long struct {
+		A                                               int
+		ThisIsAVeryLongFieldNameThatExceedsFortyBytesA  int
+		B                                               int
+		ThisIsAVeryLongFieldNameThatExceedsFortyBytesB  int
+		ThisIsAVeryLongFieldNameThatExceedsFortyBytesCC int
+		C                                               int
+	}
+	for _, v := range []interface{}{
+		alignItem{Short: 1, Inner: sortItem{"a", 1}, Longest: 3},
+		long{},
+		map[string]interface{}{"a": 1, "bbbb": sortItem{}, "cc": 3, "ddddd": 4, "é": 5},
+		map[sortItem]int{{"a", 1}: 1, {"b", 2}: 2},
+	} {
+		src := "package p\n\nvar v = " + cfg.Sdump(v)
+		got, err := format.Source([]byte(src))
+		if err != nil {
+			t.Errorf("unexpected error formatting %T: %v", v, err)
+			continue
+		}
+		if string(got) != src {
+			t.Errorf("gofmt changed dump of %T:\ngot:\n%s\nwant:\n%s", v, got, src)
+		}
+	}
+}
+
+// declItem is a struct for testing declaration dumps.
+type declItem struct {
+	D time.Duration
+	F float64
+	B []byte
+	M map[string]interface{}
+}
+
+// TestSdumpDecl checks that declarations include the imports they need.
+func TestSdumpDecl(t *testing.T) {
+	cfg := utter.NewDefaultConfig()
+	cfg.FloatSpecials = true
+	cfg.ByteEncoding = utter.Base64Bytes
+	cfg.LocalPackage = "utter_test"
+
+	v := declItem{
+		D: time.Second,
+		F: math.NaN(),
+		B: []byte("hi"),
+		M: map[string]interface{}{"a": []time.Month{time.May}},
+	}
+	want := `import (
+	"math"
+	"time"
+
+	"github.com/kortschak/utter"
+)
+
+var want = declItem{
+	D: time.Duration(1000000000),
+	F: float64(math.NaN()),
+	B: []uint8(utter.MustBase64("aGk=")),
+	M: map[string]interface{}{
+		string("a"): []time.Month{
+			time.Month(5),
+		},
+	},
+}
+`
+	if got := cfg.SdumpDecl("want", v); got != want {
+		t.Errorf("unexpected declaration:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	cfg.TargetPackage = "github.com/kortschak/utter_test"
+	want = `import (
+	template2 "html/template"
+	"text/template"
+)
+
+var want = []interface{}{
+	(*template.Template)(nil),
+	(*template2.Template)(nil),
+}
+`
+	if got := cfg.SdumpDecl("want", []interface{}{(*template.Template)(nil), (*htmltemplate.Template)(nil)}); got != want {
+		t.Errorf("unexpected aliased declaration:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	want = "package testdata\n\nvar want = int(1)\n"
+	if got := cfg.SdumpFile("testdata", "want", 1); got != want {
+		t.Errorf("unexpected file:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// versionLess orders semantic version strings by their numeric components.
+// Strings that are not versions are not ordered.
+func versionLess(a, b reflect.Value) bool {
+	parse := func(s string) []int {
+		if !strings.HasPrefix(s, "v") {
+			return nil
+		}
+		var parts []int
+		for _, f := range strings.Split(s[1:], ".") {
+			n, err := strconv.Atoi(f)
+			if err != nil {
+				return nil
+			}
+			parts = append(parts, n)
+		}
+		return parts
+	}
+	va, vb := parse(a.String()), parse(b.String())
+	if va == nil || vb == nil {
+		return false
+	}
+	for i := 0; i < len(va) && i < len(vb); i++ {
+		if va[i] != vb[i] {
+			return va[i] < vb[i]
+		}
+	}
+	return len(va) < len(vb)
+}
+
+// sortTag is a struct with a field tagged for sorting.
+type sortTag struct {
+	A []int `utter:"sort"`
+	B []int
+	P *[]string `utter:"sort"`
+}
+
+// sortItem is a struct for testing structural slice sorting.
+type sortItem struct {
+	Name string
+	N    int
+}
+
+// perm is a bit flag type for testing constant name rendering.
+type perm uint16
+
+const (
+	permExec perm = 1 << iota
+	permWrite
+	permRead
+
+	permAll = permRead | permWrite | permExec
+)
+
+// celsius is a named floating point type for testing special value
+// rendering.
+type celsius float64
+
+// phasor is a named complex type for testing special value rendering.
+type phasor complex64
+
+// TestSortSlicesText checks that sorting slices does not alter slices that
+// are rendered as text.
+func TestSortSlicesText(t *testing.T) {
+	text := []byte("text to be left in order")
+	for _, enc := range []utter.ByteEncoding{utter.StringBytes, utter.Base64Bytes, utter.GzipBytes} {
+		cfg := utter.NewDefaultConfig()
+		cfg.ByteEncoding = enc
+		cfg.GzipThreshold = 1
+		want := cfg.Sdump(text)
+		cfg.SortSlices = true
+		if got := cfg.Sdump(text); got != want {
+			t.Errorf("unexpected dump for encoding %d: got:%q want:%q", enc, got, want)
+		}
+	}
+
+	cfg := utter.NewDefaultConfig()
+	cfg.CommentRunes = true
+	runes := []rune("text")
+	want := cfg.Sdump(runes)
+	cfg.SortSlices = true
+	if got := cfg.Sdump(runes); got != want {
+		t.Errorf("unexpected dump for runes: got:%q want:%q", got, want)
+	}
+}
+
+// TestGzipBytes checks that gzip encoded byte slices can be decoded.
+func TestGzipBytes(t *testing.T) {
+	cfg := utter.NewDefaultConfig()
+	cfg.ByteEncoding = utter.GzipBytes
+	cfg.GzipThreshold = 8
+
+	want := bytes.Repeat([]byte("hello, world\n"), 10)
+	got := cfg.Sdump(want)
+	const prefix = `[]uint8(utter.MustGzipBase64("`
+	if !strings.HasPrefix(got, prefix) {
+		t.Fatalf("unexpected dump: %q", got)
+	}
+	enc := strings.TrimSuffix(strings.TrimPrefix(got, prefix), "\"))\n")
+	if dec := utter.MustGzipBase64(enc); !bytes.Equal(dec, want) {
+		t.Errorf("unexpected decoded bytes:\ngot: %q\nwant:%q", dec, want)
+	}
+}
+
+// TestGofmtFailure checks that dumps that cannot be formatted are written
+// unformatted and the failure is reported.
+func TestGofmtFailure(t *testing.T) {
+	cfg := utter.NewDefaultConfig()
+	cfg.Gofmt = true
+	cfg.Indent = "\x00"
+
+	var buf bytes.Buffer
+	err := cfg.FdumpErr(&buf, []int{1})
+	if err == nil {
+		t.Fatal("expected error formatting dump")
+	}
+	want := "[]int{\n\x00int(1),\n}\n// dump not formatted: "
+	if got := buf.String(); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "\n") {
+		t.Errorf("unexpected dump:\ngot:\n%q\nwant prefix:\n%q", got, want)
+	}
+	if !strings.HasPrefix(err.Error(), "utter: could not format dump: ") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// listNode is a linked list node for testing dump budgets.
+type listNode struct {
+	Next *listNode
+}
+
+// TestFdumpContext checks that dumps are aborted when they are cancelled
+// or exceed their budgets.
+func TestFdumpContext(t *testing.T) {
+	var list *listNode
+	for i := 0; i < 10000; i++ {
+		list = &listNode{Next: list}
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Output held in buffers before it is written
+	// is counted against the byte budget.
+	aligned := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		aligned[strconv.Itoa(i)] = i
+	}
+	bigBytes := make([]byte, 100000)
+	hidden := struct{ B bytes.Buffer }{*bytes.NewBuffer(bigBytes)}
+	queued := make(chan []byte, 1)
+	queued <- bigBytes
+
+	nodes := utter.NewDefaultConfig()
+	nodes.MaxNodes = 100
+	size := utter.NewDefaultConfig()
+	size.MaxBytes = 1000
+	alignSize := utter.NewDefaultConfig()
+	alignSize.AlignFields = true
+	alignSize.MaxBytes = 1000
+	commentSize := utter.NewDefaultConfig()
+	commentSize.UnexportedFields = utter.CommentForeignUnexported
+	commentSize.MaxBytes = 1000
+	chanSize := utter.NewDefaultConfig()
+	chanSize.ChanContents = utter.CommentChanContents
+	chanSize.MaxBytes = 1000
+	gofmtSize := utter.NewDefaultConfig()
+	gofmtSize.Gofmt = true
+	gofmtSize.AlignFields = true
+	gofmtSize.MaxBytes = 1000
+
+	tests := []struct {
+		cfg     *utter.ConfigState
+		ctx     context.Context
+		v       interface{}
+		wantErr error
+		comment string
+	}{
+		{cfg: utter.NewDefaultConfig(), ctx: cancelled, v: list, wantErr: context.Canceled,
+			comment: "\n// dump aborted: context canceled\n"},
+		{cfg: nodes, ctx: context.Background(), v: list, wantErr: utter.ErrNodeBudget,
+			comment: "\n// dump aborted: utter: node budget exceeded\n"},
+		{cfg: size, ctx: context.Background(), v: list, wantErr: utter.ErrByteBudget,
+			comment: "\n// dump aborted: utter: byte budget exceeded\n"},
+		{cfg: nodes, ctx: context.Background(), v: bigBytes, wantErr: utter.ErrNodeBudget,
+			comment: "\n// dump aborted: utter: node budget exceeded\n"},
+		{cfg: alignSize, ctx: context.Background(), v: aligned, wantErr: utter.ErrByteBudget,
+			comment: "\n// dump aborted: utter: byte budget exceeded\n"},
+		{cfg: commentSize, ctx: context.Background(), v: hidden, wantErr: utter.ErrByteBudget,
+			comment: "\n// dump aborted: utter: byte budget exceeded\n"},
+		{cfg: chanSize, ctx: context.Background(), v: queued, wantErr: utter.ErrByteBudget,
+			comment: "\n// dump aborted: utter: byte budget exceeded\n"},
+		{cfg: gofmtSize, ctx: context.Background(), v: aligned, wantErr: utter.ErrByteBudget,
+			comment: "\n// dump aborted: utter: byte budget exceeded\n"},
+	}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		err := test.cfg.FdumpContext(test.ctx, &buf, test.v)
+		if err != test.wantErr {
+			t.Errorf("test %d: unexpected error: got:%v want:%v", i, err, test.wantErr)
+		}
+		got := buf.String()
+		if !strings.HasSuffix(got, test.comment) {
+			t.Errorf("test %d: missing abort comment: %q", i, got)
+		}
+		if test.cfg.MaxBytes != 0 && len(got)-len(test.comment) > test.cfg.MaxBytes {
+			t.Errorf("test %d: dump exceeded byte budget: got:%d want:<=%d", i, len(got)-len(test.comment), test.cfg.MaxBytes)
+		}
+		// The partial output held when the dump
+		// was aborted is written up to the budget.
+		if test.wantErr == utter.ErrByteBudget && len(got)-len(test.comment) < test.cfg.MaxBytes/2 {
+			t.Errorf("test %d: partial dump not written: got:%d want:>=%d", i, len(got)-len(test.comment), test.cfg.MaxBytes/2)
+		}
+	}
+
+	// The node budget is enforced while pointers
+	// are found, before any of the dump is written.
+	walkNodes := utter.NewDefaultConfig()
+	walkNodes.CommentPointers = true
+	walkNodes.MaxNodes = 100
+	var buf bytes.Buffer
+	err := walkNodes.FdumpContext(context.Background(), &buf, list)
+	if err != utter.ErrNodeBudget {
+		t.Errorf("unexpected error: got:%v want:%v", err, utter.ErrNodeBudget)
+	}
+	if got, want := buf.String(), "\n// dump aborted: utter: node budget exceeded\n"; got != want {
+		t.Errorf("unexpected dump: got:%q want:%q", got, want)
+	}
+
+	buf.Reset()
+	if err := utter.FdumpContext(context.Background(), &buf, []int{1}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got, want := buf.String(), "[]int{\n int(1),\n}\n"; got != want {
+		t.Errorf("unexpected dump: got:%q want:%q", got, want)
+	}
+}
+
+// failWriter is an io.Writer that fails after n bytes have been written.
+type failWriter struct {
+	n     int
+	fails int
+}
+
+// errWrite is the error returned by failWriter.
+var errWrite = errors.New("write failed")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		w.fails++
+		return w.n, errWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// TestFdumpErr checks that errors writing dumps are returned and stop
+// the dump.
+func TestFdumpErr(t *testing.T) {
+	var list *listNode
+	for i := 0; i < 100; i++ {
+		list = &listNode{Next: list}
+	}
+	gofmt := utter.NewDefaultConfig()
+	gofmt.Gofmt = true
+	hexDump := utter.NewDefaultConfig()
+
+	tests := []struct {
+		cfg *utter.ConfigState
+		v   interface{}
+	}{
+		{cfg: utter.NewDefaultConfig(), v: list},
+		{cfg: gofmt, v: list},
+		{cfg: hexDump, v: bytes.Repeat([]byte("utter"), 100)},
+	}
+	for i, test := range tests {
+		w := &failWriter{n: 100}
+		err := test.cfg.FdumpErr(w, test.v)
+		if err != errWrite {
+			t.Errorf("test %d: unexpected error: got:%v want:%v", i, err, errWrite)
+		}
+		if w.fails != 1 {
+			t.Errorf("test %d: unexpected number of failed writes: got:%d want:1", i, w.fails)
+		}
+	}
+
+	if err := utter.FdumpErr(&failWriter{n: 100}, 1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := utter.FdumpErr(&failWriter{n: 1}, nil); err != errWrite {
+		t.Errorf("unexpected error for nil: got:%v want:%v", err, errWrite)
+	}
+}
+
+// newClosure returns a function literal for testing func name rendering.
+//
+//go:noinline
+func newClosure() func() int {
+	var n int
+	return func() int {
+		n++
+		return n
 	}
 }
 
@@ -373,3 +1251,22 @@ func TestSpew(t *testing.T) {
 		}
 	}
 }
+
+// TestSpewGofmt ensures that the tests of configuration options produce
+// syntactically valid Go when the Gofmt option is also set.
+func TestSpewGofmt(t *testing.T) {
+	initSpewTests()
+
+	for i, test := range utterTests {
+		cfg := utter.NewDefaultConfig()
+		if test.cs != nil {
+			*cfg = *test.cs
+		}
+		cfg.Gofmt = true
+		var buf bytes.Buffer
+		err := cfg.FdumpErr(&buf, test.in)
+		if err != nil {
+			t.Errorf("ConfigState #%d: unexpected error: %v\n%s", i, err, buf.Bytes())
+		}
+	}
+}
@@ -23,11 +23,7 @@ import "reflect"
 func (d *dumpState) walkPtr(v reflect.Value) {
 	// Remove pointers at or below the current depth from map used to detect
 	// circular refs.
-	for k, depth := range d.pointers {
-		if depth >= d.depth {
-			delete(d.pointers, k)
-		}
-	}
+	d.pointers.forgetDeeper(d.depth - 1)
 
 	var nilFound, cycleFound bool
 	for v.Kind() == reflect.Ptr {
@@ -36,11 +32,11 @@ func (d *dumpState) walkPtr(v reflect.Value) {
 			break
 		}
 		addr := v.Pointer()
-		if pd, ok := d.pointers[addr]; ok && pd < d.depth {
+		if pd, ok := d.pointers.depth(addr); ok && pd < d.depth {
 			cycleFound = true
 			break
 		}
-		d.pointers[addr] = d.depth
+		d.pointers.add(addr, d.depth)
 		d.nodes[addrType{addr, v.Type()}] = struct{}{}
 
 		v = v.Elem()
@@ -74,6 +70,8 @@ func (d *dumpState) walkSlice(v reflect.Value) {
 // appropriately.  It is a recursive function, however circular data structures
 // are detected and escaped from.
 func (d *dumpState) walk(v reflect.Value, _, _, _ bool, _ uintptr) {
+	d.visit()
+
 	// Handle invalid reflect values immediately.
 	kind := v.Kind()
 	if kind == reflect.Invalid {
@@ -116,6 +114,9 @@ func (d *dumpState) walk(v reflect.Value, _, _, _ bool, _ uintptr) {
 			if d.cs.IgnoreUnexported && vtf.PkgPath != "" {
 				continue
 			}
+			if d.unexportedFields() == OmitForeignUnexported && d.foreignUnexported(vtf) {
+				continue
+			}
 			d.walk(d.unpackValue(v.Field(i)))
 		}
 		d.depth--